@@ -0,0 +1,115 @@
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// InstanceConditionType is the type of an InstanceCondition
+type InstanceConditionType string
+
+const (
+	// InstancePodScheduled is True if the pod of the Instance has been scheduled to a node
+	InstancePodScheduled InstanceConditionType = "PodScheduled"
+
+	// InstanceReady is True if the Instance is running and its pod is ready
+	InstanceReady InstanceConditionType = "Ready"
+
+	// InstanceFull is True if no more players can join the Instance
+	InstanceFull InstanceConditionType = "Full"
+
+	// InstanceEventsDelivered is False if the last event of the Instance could not be delivered
+	InstanceEventsDelivered InstanceConditionType = "EventsDelivered"
+
+	// InstanceDraining is True while the Instance is deleted and waits for its players to leave
+	InstanceDraining InstanceConditionType = "Draining"
+
+	// InstanceAllocated is True if the Instance has been claimed by an InstanceAllocation
+	InstanceAllocated InstanceConditionType = "Allocated"
+
+	// InstanceDuplicatePods is True if the Instance owns other pods besides its own
+	InstanceDuplicatePods InstanceConditionType = "DuplicatePods"
+)
+
+// InstanceCondition describes one aspect of the state of an Instance
+type InstanceCondition struct {
+	// Type of the condition
+	Type InstanceConditionType `json:"type"`
+
+	// Status of the condition, one of True, False or Unknown
+	// +kubebuilder:validation:Enum=True;False;Unknown
+	Status corev1.ConditionStatus `json:"status"`
+
+	// ObservedGeneration is the generation of the Instance the condition was set for
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// LastTransitionTime is the last time the status of the condition changed
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+
+	// Reason is a CamelCase reason for the last transition of the condition
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Message is a human readable message about the last transition
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// Condition returns the condition of the given type or nil if it is not set
+func (s *InstanceStatus) Condition(conditionType InstanceConditionType) *InstanceCondition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == conditionType {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// SetCondition adds the condition or updates the condition of the same type.
+// The LastTransitionTime is set to now if the status of the condition changed.
+// It reports whether the condition has been added or its status changed.
+func (s *InstanceStatus) SetCondition(condition InstanceCondition) bool {
+	existing := s.Condition(condition.Type)
+	if existing == nil {
+		if condition.LastTransitionTime.IsZero() {
+			condition.LastTransitionTime = metav1.Now()
+		}
+		s.Conditions = append(s.Conditions, condition)
+		return true
+	}
+
+	transitioned := existing.Status != condition.Status
+	if transitioned {
+		existing.Status = condition.Status
+		existing.LastTransitionTime = condition.LastTransitionTime
+		if existing.LastTransitionTime.IsZero() {
+			existing.LastTransitionTime = metav1.Now()
+		}
+	}
+	existing.Reason = condition.Reason
+	existing.Message = condition.Message
+	existing.ObservedGeneration = condition.ObservedGeneration
+	return transitioned
+}
+
+// RemoveCondition removes the condition of the given type
+func (s *InstanceStatus) RemoveCondition(conditionType InstanceConditionType) {
+	conditions := s.Conditions[:0]
+	for _, condition := range s.Conditions {
+		if condition.Type != conditionType {
+			conditions = append(conditions, condition)
+		}
+	}
+	if len(conditions) == 0 {
+		conditions = nil
+	}
+	s.Conditions = conditions
+}
+
+// IsConditionTrue reports whether the condition of the given type is set and True
+func (s *InstanceStatus) IsConditionTrue(conditionType InstanceConditionType) bool {
+	condition := s.Condition(conditionType)
+	return condition != nil && condition.Status == corev1.ConditionTrue
+}
@@ -0,0 +1,38 @@
+package v1
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestInstanceStatus_SetCondition(t *testing.T) {
+	var status InstanceStatus
+	past := metav1.NewTime(time.Now().Add(-time.Hour))
+
+	if !status.SetCondition(InstanceCondition{Type: InstanceReady, Status: corev1.ConditionFalse, Reason: "Initializing", LastTransitionTime: past}) {
+		t.Error("adding a condition should report a transition")
+	}
+	if status.SetCondition(InstanceCondition{Type: InstanceReady, Status: corev1.ConditionFalse, Reason: "PodNotReady", ObservedGeneration: 2}) {
+		t.Error("changing the reason should not report a transition")
+	}
+	ready := status.Condition(InstanceReady)
+	if ready.Reason != "PodNotReady" || ready.ObservedGeneration != 2 || !ready.LastTransitionTime.Equal(&past) {
+		t.Errorf("unexpected condition after update: %+v", ready)
+	}
+
+	if !status.SetCondition(InstanceCondition{Type: InstanceReady, Status: corev1.ConditionTrue, Reason: "PodReady"}) {
+		t.Error("changing the status should report a transition")
+	}
+	if !status.IsConditionTrue(InstanceReady) || status.Condition(InstanceReady).LastTransitionTime.Equal(&past) {
+		t.Errorf("unexpected condition after transition: %+v", status.Condition(InstanceReady))
+	}
+
+	status.SetCondition(InstanceCondition{Type: InstanceFull, Status: corev1.ConditionTrue})
+	status.RemoveCondition(InstanceReady)
+	if len(status.Conditions) != 1 || status.Condition(InstanceReady) != nil {
+		t.Errorf("unexpected conditions after removal: %+v", status.Conditions)
+	}
+}
@@ -0,0 +1,59 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// InstanceDefaulterPath is the path the InstanceDefaulter is served on
+const InstanceDefaulterPath = "/mutate-instance-cow-network-v1-instance"
+
+// +kubebuilder:webhook:verbs=create,path=/mutate-instance-cow-network-v1-instance,mutating=true,failurePolicy=fail,sideEffects=None,admissionReviewVersions=v1beta1,groups=instance.cow.network,resources=instances,versions=v1,name=minstance.instance.cow.network
+// +kubebuilder:rbac:groups=instance.cow.network,resources=instancedefaults,verbs=get;list;watch
+
+// InstanceDefaulter fills the defaults of the InstanceDefaults object called Name into new Instances.
+// Instances are admitted unchanged if the object does not exist.
+// +kubebuilder:object:generate=false
+type InstanceDefaulter struct {
+	Client  client.Client
+	Name    string
+	decoder *admission.Decoder
+}
+
+var _ admission.Handler = &InstanceDefaulter{}
+var _ admission.DecoderInjector = &InstanceDefaulter{}
+
+// InjectDecoder implements admission.DecoderInjector
+func (d *InstanceDefaulter) InjectDecoder(decoder *admission.Decoder) error {
+	d.decoder = decoder
+	return nil
+}
+
+// Handle implements admission.Handler
+func (d *InstanceDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var instance Instance
+	if err := d.decoder.Decode(req, &instance); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	var defaults InstanceDefaults
+	if err := d.Client.Get(ctx, client.ObjectKey{Name: d.Name}, &defaults); err != nil {
+		if apierrors.IsNotFound(err) {
+			return admission.Allowed("no instance defaults configured")
+		}
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	defaults.Spec.Apply(&instance)
+
+	marshaled, err := json.Marshal(&instance)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
@@ -27,16 +27,106 @@ const (
 	StateEnding InstanceState = "Ending"
 )
 
+// InstanceRestartPolicy describes whether the pod of an Instance is recreated once it died
+// +kubebuilder:validation:Enum=Never;OnFailure;Always
+type InstanceRestartPolicy string
+
+const (
+	// RestartPolicyNever removes the Instance once its pod died
+	RestartPolicyNever InstanceRestartPolicy = "Never"
+
+	// RestartPolicyOnFailure recreates the pod if it failed or disappeared
+	RestartPolicyOnFailure InstanceRestartPolicy = "OnFailure"
+
+	// RestartPolicyAlways recreates the pod whenever it terminated or disappeared
+	RestartPolicyAlways InstanceRestartPolicy = "Always"
+)
+
+// InstanceFinalizer is added to every Instance to gracefully tear it down on deletion
+const InstanceFinalizer = "instance.cow.network/finalizer"
+
+// DefaultDrainTimeoutSeconds is used if no drain timeout is specified
+const DefaultDrainTimeoutSeconds = 60
+
+// DefaultRestartBackoffSeconds is used if no restart backoff is specified
+const DefaultRestartBackoffSeconds = 10
+
+// MaxRestartBackoffSeconds caps the delay between two restarts
+const MaxRestartBackoffSeconds = 300
+
 // InstanceSpec defines the desired state of Instance
 type InstanceSpec struct {
-	// Template defines the underlying pod that will be started when creating the Instance
+	// Template defines the underlying pod that will be started when creating the Instance.
+	// It is the primary pod of the Instance, the Instance is lost once it is gone.
+	Template corev1.PodSpec `json:"template"`
+
+	// Pods defines companion pods started together with the primary pod, e.g. a voice relay
+	// or a replay recorder. They are recreated whenever they terminated or disappeared
+	// as long as the Instance is not ending.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Pods []InstancePodTemplate `json:"pods,omitempty"`
+
+	// DrainTimeoutSeconds is the maximum time connected players are given to leave the
+	// Instance after it has been deleted. The pod is deleted as soon as all players left
+	// or the timeout expired. Defaults to 60 seconds.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	DrainTimeoutSeconds *int64 `json:"drainTimeoutSeconds,omitempty"`
+
+	// RestartPolicy defines whether the pod is recreated once it died instead of removing the Instance.
+	// Defaults to Never.
+	// +optional
+	RestartPolicy InstanceRestartPolicy `json:"restartPolicy,omitempty"`
+
+	// MaxRestarts limits how often the pod is recreated. The Instance is removed once
+	// the limit is reached. There is no limit if it is not set.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxRestarts *int32 `json:"maxRestarts,omitempty"`
+
+	// RestartBackoffSeconds is the delay between the first two restarts. The delay doubles
+	// with every further restart, up to 5 minutes. Defaults to 10 seconds.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	RestartBackoffSeconds *int64 `json:"restartBackoffSeconds,omitempty"`
+
+	// Capacity defines how many players fit into the Instance.
+	// The capacity is unlimited if it is not set.
+	// +optional
+	Capacity *InstanceCapacity `json:"capacity,omitempty"`
+}
+
+// InstancePodTemplate defines a companion pod of an Instance
+type InstancePodTemplate struct {
+	// Name of the companion pod. The pod is named after the ID of the Instance followed by this name.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	// +kubebuilder:validation:MaxLength=26
+	Name string `json:"name"`
+
+	// Template defines the pod
 	Template corev1.PodSpec `json:"template"`
 }
 
+// InstanceCapacity defines how many players fit into an Instance
+type InstanceCapacity struct {
+	// MaxPlayers is the maximum number of players connected at the same time
+	// +kubebuilder:validation:Minimum=1
+	MaxPlayers int32 `json:"maxPlayers"`
+
+	// ReservedSlots are kept free for players the application lets in on its own,
+	// e.g. staff or members of a party. They are not counted as free slots.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	ReservedSlots int32 `json:"reservedSlots,omitempty"`
+}
+
 // InstanceStatus defines the observed state of Instance
 type InstanceStatus struct {
 
-	// State holds the current observed state of the instance
+	// State holds the current observed state of the instance.
+	// It is derived from the phase and readiness of the pod.
 	State InstanceState `json:"state,omitempty"`
 
 	// IP address assigned to the Instance
@@ -45,13 +135,103 @@ type InstanceStatus struct {
 	// Unique ID of the instance
 	ID string `json:"id,omitempty"`
 
+	// PodName is the name of the pod of the instance.
+	// The ID is used as name if it is empty.
+	// +optional
+	PodName string `json:"podName,omitempty"`
+
+	// NodeName is the name of the node the pod of the instance is scheduled to
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
+
 	// Metadata holds application specific metadata about the instance
 	Metadata InstanceMetadata `json:"metadata,omitempty"`
+
+	// RestartCount is the number of times the pod has been recreated
+	RestartCount int32 `json:"restartCount,omitempty"`
+
+	// LastRestartTime is the time the pod was recreated the last time
+	LastRestartTime *metav1.Time `json:"lastRestartTime,omitempty"`
+
+	// Allocation is set once the Instance has been claimed by an InstanceAllocation
+	Allocation *AllocationRef `json:"allocation,omitempty"`
+
+	// PlayerCount is the number of players connected to the Instance
+	PlayerCount int32 `json:"playerCount"`
+
+	// FreeSlots is the number of players that can still join the Instance.
+	// It is only set if the Instance has a capacity.
+	// +optional
+	FreeSlots *int32 `json:"freeSlots,omitempty"`
+
+	// Conditions describe the current state of the Instance
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []InstanceCondition `json:"conditions,omitempty"`
+
+	// Pods holds the status of the companion pods defined in spec.pods.
+	// The primary pod is described by the other fields of the status.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Pods []InstancePodStatus `json:"pods,omitempty"`
+
+	// ObservedMetadata describes the metadata last observed by the controller.
+	// It is used to detect changes of the state and players the events are emitted for.
+	// +optional
+	ObservedMetadata *ObservedMetadata `json:"observedMetadata,omitempty"`
+}
+
+// ObservedMetadata describes the metadata of an Instance last observed by the controller
+type ObservedMetadata struct {
+	// Hash of the canonical JSON encoding of the metadata
+	Hash string `json:"hash"`
+
+	// State is the last observed state of the application.
+	// It is sent as old state with the next state changed event.
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	State json.RawMessage `json:"state,omitempty"`
+
+	// Players are the last observed players.
+	// They are sent with the player left events of the players that left.
+	// +optional
+	Players []InstancePlayer `json:"players,omitempty"`
+}
+
+// InstancePodStatus describes a companion pod of an Instance
+type InstancePodStatus struct {
+	// Name of the companion pod in spec.pods
+	Name string `json:"name"`
+
+	// PodName is the name of the pod
+	PodName string `json:"podName"`
+
+	// Phase of the pod, empty if the pod does not exist
+	// +optional
+	Phase corev1.PodPhase `json:"phase,omitempty"`
+
+	// Ready is true if the pod is ready
+	Ready bool `json:"ready"`
+
+	// IP address assigned to the pod
+	// +optional
+	IP string `json:"ip,omitempty"`
+
+	// NodeName is the name of the node the pod is scheduled to
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
 }
 
 // InstanceMetadata defines the metadata of the Instance
 type InstanceMetadata struct {
 	// State holds the current observed state of the application.
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +kubebuilder:pruning:PreserveUnknownFields
 	State json.RawMessage `json:"state,omitempty"`
 
 	// Players currently connected to this Instance.
@@ -61,13 +241,27 @@ type InstanceMetadata struct {
 // InstancePlayer defines metadata of a player connected to this instance
 type InstancePlayer struct {
 	// ID of this player
+	// +kubebuilder:validation:MinLength=1
 	ID string `json:"id"`
 
 	// Metadata contains custom metadata about this player
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +kubebuilder:pruning:PreserveUnknownFields
 	Metadata json.RawMessage `json:"metadata,omitempty"`
 }
 
 // +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=inst,categories=cow
+// +kubebuilder:printcolumn:name="State",type=string,JSONPath=`.status.state`
+// +kubebuilder:printcolumn:name="ID",type=string,JSONPath=`.status.id`
+// +kubebuilder:printcolumn:name="IP",type=string,JSONPath=`.status.ip`
+// +kubebuilder:printcolumn:name="Players",type=integer,JSONPath=`.status.playerCount`
+// +kubebuilder:printcolumn:name="Free",type=integer,JSONPath=`.status.freeSlots`,priority=1
+// +kubebuilder:printcolumn:name="Full",type=string,JSONPath=`.status.conditions[?(@.type=="Full")].status`,priority=1
+// +kubebuilder:printcolumn:name="Node",type=string,JSONPath=`.status.nodeName`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Instance is the Schema for the instances API
 type Instance struct {
@@ -0,0 +1,128 @@
+package v1
+
+import (
+	"encoding/json"
+
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+func (r *Instance) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-instance-cow-network-v1-instance,mutating=false,failurePolicy=fail,sideEffects=None,admissionReviewVersions=v1beta1,groups=instance.cow.network,resources=instances,versions=v1,name=vinstance.instance.cow.network
+
+var _ webhook.Validator = &Instance{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Instance) ValidateCreate() error {
+	var errs field.ErrorList
+	errs = append(errs, r.validateTemplate()...)
+	errs = append(errs, r.validateCapacity()...)
+	if len(r.Status.ID) > 0 {
+		errs = append(errs, field.Forbidden(field.NewPath("status", "id"), "is assigned by the controller"))
+	}
+	errs = append(errs, r.validateMetadata()...)
+	return r.toError(errs)
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Instance) ValidateUpdate(old runtime.Object) error {
+	oldInstance := old.(*Instance)
+
+	var errs field.ErrorList
+	errs = append(errs, r.validateCapacity()...)
+	if !apiequality.Semantic.DeepEqual(r.Spec.Template, oldInstance.Spec.Template) {
+		errs = append(errs, field.Forbidden(field.NewPath("spec", "template"), "is immutable"))
+	}
+	if !apiequality.Semantic.DeepEqual(r.Spec.Pods, oldInstance.Spec.Pods) {
+		errs = append(errs, field.Forbidden(field.NewPath("spec", "pods"), "is immutable"))
+	}
+	// the ID is set once by the controller when the pod is created. Writes to the status
+	// subresource are not intercepted, the controller restores the ID changed there.
+	if len(oldInstance.Status.ID) > 0 && r.Status.ID != oldInstance.Status.ID {
+		errs = append(errs, field.Forbidden(field.NewPath("status", "id"), "is immutable"))
+	}
+	// don't block updates of instances whose metadata has been written before the webhook existed
+	if !apiequality.Semantic.DeepEqual(r.Status.Metadata, oldInstance.Status.Metadata) {
+		errs = append(errs, r.validateMetadata()...)
+	}
+	return r.toError(errs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Instance) ValidateDelete() error {
+	return nil
+}
+
+func (r *Instance) validateTemplate() field.ErrorList {
+	var errs field.ErrorList
+	if len(r.Spec.Template.Containers) == 0 {
+		errs = append(errs, field.Required(field.NewPath("spec", "template", "containers"), "must contain at least one container"))
+	}
+
+	names := make(map[string]bool, len(r.Spec.Pods))
+	for i, pod := range r.Spec.Pods {
+		path := field.NewPath("spec", "pods").Index(i)
+		if names[pod.Name] {
+			errs = append(errs, field.Duplicate(path.Child("name"), pod.Name))
+		}
+		names[pod.Name] = true
+		if len(pod.Template.Containers) == 0 {
+			errs = append(errs, field.Required(path.Child("template", "containers"), "must contain at least one container"))
+		}
+	}
+	return errs
+}
+
+func (r *Instance) validateCapacity() field.ErrorList {
+	var errs field.ErrorList
+	if capacity := r.Spec.Capacity; capacity != nil && capacity.ReservedSlots >= capacity.MaxPlayers {
+		errs = append(errs, field.Invalid(field.NewPath("spec", "capacity", "reservedSlots"), capacity.ReservedSlots, "must be less than maxPlayers"))
+	}
+	return errs
+}
+
+// validateMetadata makes sure the application metadata can be converted into the
+// structs sent with the instance events
+func (r *Instance) validateMetadata() field.ErrorList {
+	path := field.NewPath("status", "metadata")
+
+	var errs field.ErrorList
+	if !isJSONObject(r.Status.Metadata.State) {
+		errs = append(errs, field.Invalid(path.Child("state"), string(r.Status.Metadata.State), "must be a JSON object"))
+	}
+	for i, player := range r.Status.Metadata.Players {
+		if len(player.ID) == 0 {
+			errs = append(errs, field.Required(path.Child("players").Index(i).Child("id"), ""))
+		}
+		if !isJSONObject(player.Metadata) {
+			errs = append(errs, field.Invalid(path.Child("players").Index(i).Child("metadata"), string(player.Metadata), "must be a JSON object"))
+		}
+	}
+	return errs
+}
+
+func (r *Instance) toError(errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(schema.GroupKind{Group: GroupVersion.Group, Kind: "Instance"}, r.Name, errs)
+}
+
+// isJSONObject reports whether raw is empty or holds a JSON object
+func isJSONObject(raw json.RawMessage) bool {
+	if len(raw) == 0 {
+		return true
+	}
+	var object map[string]interface{}
+	return json.Unmarshal(raw, &object) == nil && object != nil
+}
@@ -0,0 +1,90 @@
+package v1
+
+import (
+	"encoding/json"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func validInstance() *Instance {
+	return &Instance{
+		Spec: InstanceSpec{
+			Template: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "game", Image: "game:latest"}},
+			},
+		},
+	}
+}
+
+func TestInstance_ValidateCreate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(*Instance)
+		wantErr bool
+	}{
+		{"valid", func(*Instance) {}, false},
+		{"no containers", func(i *Instance) { i.Spec.Template.Containers = nil }, true},
+		{"id set", func(i *Instance) { i.Status.ID = "abc" }, true},
+		{"capacity", func(i *Instance) { i.Spec.Capacity = &InstanceCapacity{MaxPlayers: 16, ReservedSlots: 2} }, false},
+		{"all slots reserved", func(i *Instance) { i.Spec.Capacity = &InstanceCapacity{MaxPlayers: 2, ReservedSlots: 2} }, true},
+		{"state object", func(i *Instance) { i.Status.Metadata.State = json.RawMessage(`{"map":"lobby"}`) }, false},
+		{"state array", func(i *Instance) { i.Status.Metadata.State = json.RawMessage(`[1,2]`) }, true},
+		{"state null", func(i *Instance) { i.Status.Metadata.State = json.RawMessage(`null`) }, true},
+		{"player metadata string", func(i *Instance) {
+			i.Status.Metadata.Players = []InstancePlayer{{ID: "p", Metadata: json.RawMessage(`"foo"`)}}
+		}, true},
+		{"player without id", func(i *Instance) {
+			i.Status.Metadata.Players = []InstancePlayer{{Metadata: json.RawMessage(`{}`)}}
+		}, true},
+		{"companion pod", func(i *Instance) {
+			i.Spec.Pods = []InstancePodTemplate{{Name: "voice", Template: i.Spec.Template}}
+		}, false},
+		{"companion pod without containers", func(i *Instance) {
+			i.Spec.Pods = []InstancePodTemplate{{Name: "voice"}}
+		}, true},
+		{"duplicate companion pods", func(i *Instance) {
+			i.Spec.Pods = []InstancePodTemplate{{Name: "voice", Template: i.Spec.Template}, {Name: "voice", Template: i.Spec.Template}}
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := validInstance()
+			tt.mutate(instance)
+			if err := instance.ValidateCreate(); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateCreate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInstance_ValidateUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		oldID   string
+		mutate  func(*Instance)
+		wantErr bool
+	}{
+		{"unchanged", "", func(*Instance) {}, false},
+		{"template changed", "", func(i *Instance) { i.Spec.Template.Containers[0].Image = "game:next" }, true},
+		{"pods changed", "", func(i *Instance) {
+			i.Spec.Pods = []InstancePodTemplate{{Name: "voice", Template: i.Spec.Template}}
+		}, true},
+		{"id assigned", "", func(i *Instance) { i.Status.ID = "abc" }, false},
+		{"id kept", "abc", func(i *Instance) { i.Status.ID = "abc" }, false},
+		{"id changed", "abc", func(i *Instance) { i.Status.ID = "def" }, true},
+		{"id removed", "abc", func(i *Instance) { i.Status.ID = "" }, true},
+		{"state array", "", func(i *Instance) { i.Status.Metadata.State = json.RawMessage(`[]`) }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := validInstance()
+			old.Status.ID = tt.oldID
+			instance := old.DeepCopy()
+			tt.mutate(instance)
+			if err := instance.ValidateUpdate(old); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateUpdate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package v1
+
+import (
+	"encoding/json"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// AllocationState is the current state of an InstanceAllocation
+// +kubebuilder:validation:Enum=Pending;Allocated
+type AllocationState string
+
+const (
+	// AllocationStatePending indicates that no matching Instance was available yet
+	AllocationStatePending AllocationState = "Pending"
+
+	// AllocationStateAllocated indicates that an Instance has been claimed
+	AllocationStateAllocated AllocationState = "Allocated"
+)
+
+// AllocationFinalizer is added to every InstanceAllocation to release the claimed Instance on deletion
+const AllocationFinalizer = "instance.cow.network/allocation"
+
+// InstanceAllocationSpec defines the desired state of InstanceAllocation
+type InstanceAllocationSpec struct {
+	// Selector selects the Instances that may be allocated
+	Selector metav1.LabelSelector `json:"selector"`
+
+	// Metadata of the requester, e.g. the match the Instance is allocated for.
+	// It is recorded on the allocated Instance.
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+}
+
+// InstanceAllocationStatus defines the observed state of InstanceAllocation
+type InstanceAllocationStatus struct {
+	// State of the allocation
+	State AllocationState `json:"state,omitempty"`
+
+	// InstanceName is the name of the allocated Instance
+	InstanceName string `json:"instanceName,omitempty"`
+
+	// InstanceID is the unique ID of the allocated Instance
+	InstanceID string `json:"instanceId,omitempty"`
+
+	// IP address of the allocated Instance
+	IP string `json:"ip,omitempty"`
+}
+
+// AllocationRef is recorded on an Instance once it has been allocated
+type AllocationRef struct {
+	// Name of the InstanceAllocation that claimed the Instance
+	Name string `json:"name"`
+
+	// UID of the InstanceAllocation that claimed the Instance
+	UID types.UID `json:"uid"`
+
+	// Metadata of the requester
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+
+	// Time the Instance has been allocated
+	Time metav1.Time `json:"time"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:categories=cow
+
+// InstanceAllocation is the Schema for the instanceallocations API.
+// It claims a single Running Instance matching its selector. An Instance
+// is never handed out to more than one allocation.
+type InstanceAllocation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   InstanceAllocationSpec   `json:"spec,omitempty"`
+	Status InstanceAllocationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// InstanceAllocationList contains a list of InstanceAllocation
+type InstanceAllocationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []InstanceAllocation `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&InstanceAllocation{}, &InstanceAllocationList{})
+}
@@ -0,0 +1,81 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// DefaultScaleDownDelaySeconds is used if no scale down delay is specified
+const DefaultScaleDownDelaySeconds = 60
+
+// InstanceAutoscalerSpec defines the desired state of InstanceAutoscaler
+type InstanceAutoscalerSpec struct {
+	// InstanceSetName is the name of the InstanceSet that is scaled
+	InstanceSetName string `json:"instanceSetName"`
+
+	// Buffer is the amount of free Instances that is kept available. It is either an absolute
+	// number of Instances or a percentage of all Instances of the set, e.g. "20%".
+	// An Instance is free if it has not been allocated and is not full, or has no players
+	// if it has no capacity.
+	// +kubebuilder:validation:XIntOrString
+	// +kubebuilder:validation:Pattern=`^[0-9]{1,2}%$`
+	Buffer intstr.IntOrString `json:"buffer"`
+
+	// MinReplicas is the lower limit for the number of Instances
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinReplicas int32 `json:"minReplicas,omitempty"`
+
+	// MaxReplicas is the upper limit for the number of Instances.
+	// It must not be less than MinReplicas.
+	// +kubebuilder:validation:Minimum=0
+	MaxReplicas int32 `json:"maxReplicas"`
+
+	// ScaleDownDelaySeconds is the minimum time between the last scaling and scaling down.
+	// Scaling up is not delayed. Defaults to 60 seconds.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	ScaleDownDelaySeconds *int64 `json:"scaleDownDelaySeconds,omitempty"`
+}
+
+// InstanceAutoscalerStatus defines the observed state of InstanceAutoscaler
+type InstanceAutoscalerStatus struct {
+	// CurrentReplicas is the number of Instances of the set that are not ending
+	CurrentReplicas int32 `json:"currentReplicas"`
+
+	// FreeReplicas is the number of free Instances of the set
+	FreeReplicas int32 `json:"freeReplicas"`
+
+	// DesiredReplicas is the number of Instances computed from the buffer
+	DesiredReplicas int32 `json:"desiredReplicas"`
+
+	// LastScaleTime is the last time the replicas of the set have been changed
+	// +optional
+	LastScaleTime *metav1.Time `json:"lastScaleTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:categories=cow
+
+// InstanceAutoscaler is the Schema for the instanceautoscalers API
+type InstanceAutoscaler struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   InstanceAutoscalerSpec   `json:"spec,omitempty"`
+	Status InstanceAutoscalerStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// InstanceAutoscalerList contains a list of InstanceAutoscaler
+type InstanceAutoscalerList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []InstanceAutoscaler `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&InstanceAutoscaler{}, &InstanceAutoscalerList{})
+}
@@ -0,0 +1,71 @@
+package v1
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+func (r *InstanceAutoscaler) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-instance-cow-network-v1-instanceautoscaler,mutating=false,failurePolicy=fail,sideEffects=None,admissionReviewVersions=v1beta1,groups=instance.cow.network,resources=instanceautoscalers,versions=v1,name=vinstanceautoscaler.instance.cow.network
+
+var _ webhook.Validator = &InstanceAutoscaler{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *InstanceAutoscaler) ValidateCreate() error {
+	return r.validate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *InstanceAutoscaler) ValidateUpdate(old runtime.Object) error {
+	return r.validate()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *InstanceAutoscaler) ValidateDelete() error {
+	return nil
+}
+
+func (r *InstanceAutoscaler) validate() error {
+	var errs field.ErrorList
+	if _, _, err := r.Spec.BufferPercentage(); err != nil {
+		errs = append(errs, field.Invalid(field.NewPath("spec", "buffer"), r.Spec.Buffer.String(), err.Error()))
+	}
+	if r.Spec.MinReplicas > r.Spec.MaxReplicas {
+		errs = append(errs, field.Invalid(field.NewPath("spec", "minReplicas"), r.Spec.MinReplicas, "must not be greater than maxReplicas"))
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(schema.GroupKind{Group: GroupVersion.Group, Kind: "InstanceAutoscaler"}, r.Name, errs)
+}
+
+// BufferPercentage returns the percentage of a relative buffer. ok is false if the
+// buffer is an absolute number of Instances. An error is returned for invalid buffers.
+func (s *InstanceAutoscalerSpec) BufferPercentage() (percent int32, ok bool, err error) {
+	if s.Buffer.Type != intstr.String {
+		if s.Buffer.IntVal < 0 {
+			return 0, false, fmt.Errorf("must not be negative")
+		}
+		return 0, false, nil
+	}
+
+	p, err := strconv.Atoi(strings.TrimSuffix(s.Buffer.StrVal, "%"))
+	if err != nil || !strings.HasSuffix(s.Buffer.StrVal, "%") || p < 0 || p >= 100 {
+		return 0, true, fmt.Errorf("must be a percentage between 0%% and 99%%")
+	}
+	return int32(p), true, nil
+}
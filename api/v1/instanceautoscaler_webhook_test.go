@@ -0,0 +1,31 @@
+package v1
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestInstanceAutoscaler_ValidateCreate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(*InstanceAutoscaler)
+		wantErr bool
+	}{
+		{"valid", func(*InstanceAutoscaler) {}, false},
+		{"percentage", func(a *InstanceAutoscaler) { a.Spec.Buffer = intstr.FromString("25%") }, false},
+		{"negative buffer", func(a *InstanceAutoscaler) { a.Spec.Buffer = intstr.FromInt(-1) }, true},
+		{"percentage too large", func(a *InstanceAutoscaler) { a.Spec.Buffer = intstr.FromString("100%") }, true},
+		{"no percent sign", func(a *InstanceAutoscaler) { a.Spec.Buffer = intstr.FromString("20") }, true},
+		{"min greater than max", func(a *InstanceAutoscaler) { a.Spec.MinReplicas = 11 }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			autoscaler := &InstanceAutoscaler{Spec: InstanceAutoscalerSpec{Buffer: intstr.FromInt(2), MinReplicas: 1, MaxReplicas: 10}}
+			tt.mutate(autoscaler)
+			if err := autoscaler.ValidateCreate(); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateCreate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
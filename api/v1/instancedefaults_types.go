@@ -0,0 +1,107 @@
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// InstanceDefaultsSpec defines the defaults applied to newly created Instances.
+// Values that are already set on the Instance are never overwritten.
+type InstanceDefaultsSpec struct {
+	// RestartPolicy of the pod
+	// +optional
+	RestartPolicy corev1.RestartPolicy `json:"restartPolicy,omitempty"`
+
+	// TerminationGracePeriodSeconds of the pod
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TerminationGracePeriodSeconds *int64 `json:"terminationGracePeriodSeconds,omitempty"`
+
+	// Labels added to the Instance and therefore also to its pods
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Resources are the requests and limits of every container that does not specify them
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// ContainerPort is added to the first container of every pod if it does not expose any port
+	// +optional
+	ContainerPort *corev1.ContainerPort `json:"containerPort,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster,categories=cow
+
+// InstanceDefaults is the Schema for the instancedefaults API
+type InstanceDefaults struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec InstanceDefaultsSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// InstanceDefaultsList contains a list of InstanceDefaults
+type InstanceDefaultsList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []InstanceDefaults `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&InstanceDefaults{}, &InstanceDefaultsList{})
+}
+
+// Apply fills all fields of instance that are not set with the defaults.
+// The defaults of the pod are applied to spec.template and the templates of all companion pods.
+func (d *InstanceDefaultsSpec) Apply(instance *Instance) {
+	d.applyTemplate(&instance.Spec.Template)
+	for i := range instance.Spec.Pods {
+		d.applyTemplate(&instance.Spec.Pods[i].Template)
+	}
+
+	if len(d.Labels) > 0 && instance.Labels == nil {
+		instance.Labels = make(map[string]string, len(d.Labels))
+	}
+	for k, v := range d.Labels {
+		if _, ok := instance.Labels[k]; !ok {
+			instance.Labels[k] = v
+		}
+	}
+}
+
+// applyTemplate fills all fields of the pod template that are not set with the defaults
+func (d *InstanceDefaultsSpec) applyTemplate(template *corev1.PodSpec) {
+	if len(template.RestartPolicy) == 0 {
+		template.RestartPolicy = d.RestartPolicy
+	}
+	if template.TerminationGracePeriodSeconds == nil && d.TerminationGracePeriodSeconds != nil {
+		seconds := *d.TerminationGracePeriodSeconds
+		template.TerminationGracePeriodSeconds = &seconds
+	}
+
+	for i := range template.Containers {
+		resources := &template.Containers[i].Resources
+		resources.Requests = defaultResources(resources.Requests, d.Resources.Requests)
+		resources.Limits = defaultResources(resources.Limits, d.Resources.Limits)
+	}
+
+	if d.ContainerPort != nil && len(template.Containers) > 0 && len(template.Containers[0].Ports) == 0 {
+		template.Containers[0].Ports = []corev1.ContainerPort{*d.ContainerPort}
+	}
+}
+
+// defaultResources adds every resource of defaults missing in list
+func defaultResources(list, defaults corev1.ResourceList) corev1.ResourceList {
+	if len(defaults) > 0 && list == nil {
+		list = make(corev1.ResourceList, len(defaults))
+	}
+	for name, quantity := range defaults {
+		if _, ok := list[name]; !ok {
+			list[name] = quantity.DeepCopy()
+		}
+	}
+	return list
+}
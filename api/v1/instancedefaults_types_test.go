@@ -0,0 +1,101 @@
+package v1
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestInstanceDefaultsSpec_Apply(t *testing.T) {
+	grace := int64(30)
+	defaults := InstanceDefaultsSpec{
+		RestartPolicy:                 corev1.RestartPolicyNever,
+		TerminationGracePeriodSeconds: &grace,
+		Labels:                        map[string]string{"team": "games", "tier": "default"},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("500m"),
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+			},
+		},
+		ContainerPort: &corev1.ContainerPort{Name: "game", ContainerPort: 25565},
+	}
+
+	ownGrace := int64(5)
+	instance := &Instance{}
+	instance.Labels = map[string]string{"tier": "premium"}
+	instance.Spec.Template = corev1.PodSpec{
+		TerminationGracePeriodSeconds: &ownGrace,
+		Containers: []corev1.Container{
+			{
+				Name: "game",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+				},
+			},
+			{Name: "sidecar", Ports: []corev1.ContainerPort{{ContainerPort: 8080}}},
+		},
+	}
+	instance.Spec.Pods = []InstancePodTemplate{{
+		Name: "voice",
+		Template: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyAlways,
+			Containers:    []corev1.Container{{Name: "voice"}},
+		},
+	}}
+
+	defaults.Apply(instance)
+
+	template := instance.Spec.Template
+	if template.RestartPolicy != corev1.RestartPolicyNever {
+		t.Errorf("RestartPolicy = %q, want %q", template.RestartPolicy, corev1.RestartPolicyNever)
+	}
+	if *template.TerminationGracePeriodSeconds != ownGrace {
+		t.Errorf("TerminationGracePeriodSeconds = %d, want %d", *template.TerminationGracePeriodSeconds, ownGrace)
+	}
+	if instance.Labels["team"] != "games" || instance.Labels["tier"] != "premium" {
+		t.Errorf("Labels = %v, want team=games and tier=premium", instance.Labels)
+	}
+
+	game := template.Containers[0]
+	if cpu := game.Resources.Requests[corev1.ResourceCPU]; cpu.String() != "2" {
+		t.Errorf("cpu request = %s, want 2", cpu.String())
+	}
+	if memory := game.Resources.Requests[corev1.ResourceMemory]; memory.String() != "1Gi" {
+		t.Errorf("memory request = %s, want 1Gi", memory.String())
+	}
+	if len(game.Ports) != 1 || game.Ports[0].ContainerPort != 25565 {
+		t.Errorf("Ports = %v, want the default port", game.Ports)
+	}
+
+	sidecar := template.Containers[1]
+	if cpu := sidecar.Resources.Requests[corev1.ResourceCPU]; cpu.String() != "500m" {
+		t.Errorf("sidecar cpu request = %s, want 500m", cpu.String())
+	}
+	if len(sidecar.Ports) != 1 || sidecar.Ports[0].ContainerPort != 8080 {
+		t.Errorf("sidecar Ports = %v, want them unchanged", sidecar.Ports)
+	}
+
+	voice := instance.Spec.Pods[0].Template
+	if voice.RestartPolicy != corev1.RestartPolicyAlways {
+		t.Errorf("companion RestartPolicy = %q, want it unchanged", voice.RestartPolicy)
+	}
+	if voice.TerminationGracePeriodSeconds == nil || *voice.TerminationGracePeriodSeconds != grace {
+		t.Errorf("companion TerminationGracePeriodSeconds = %v, want %d", voice.TerminationGracePeriodSeconds, grace)
+	}
+	if memory := voice.Containers[0].Resources.Requests[corev1.ResourceMemory]; memory.String() != "1Gi" {
+		t.Errorf("companion memory request = %s, want 1Gi", memory.String())
+	}
+	if len(voice.Containers[0].Ports) != 1 || voice.Containers[0].Ports[0].ContainerPort != 25565 {
+		t.Errorf("companion Ports = %v, want the default port", voice.Containers[0].Ports)
+	}
+}
+
+func TestInstanceDefaultsSpec_ApplyEmpty(t *testing.T) {
+	instance := &Instance{}
+	(&InstanceDefaultsSpec{}).Apply(instance)
+	if instance.Labels != nil || instance.Spec.Template.TerminationGracePeriodSeconds != nil {
+		t.Errorf("empty defaults changed the instance: %+v", instance)
+	}
+}
@@ -0,0 +1,76 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// InstanceSetLabel is set on every Instance created by an InstanceSet
+// and contains the name of the InstanceSet
+const InstanceSetLabel = "instance.cow.network/set"
+
+// InstanceTemplateMeta defines the metadata of the Instances created from a template
+type InstanceTemplateMeta struct {
+	// Labels added to every created Instance
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Annotations added to every created Instance
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// InstanceTemplateSpec describes the Instances created by an InstanceSet
+type InstanceTemplateSpec struct {
+	// Metadata of the created Instances
+	// +optional
+	Metadata InstanceTemplateMeta `json:"metadata,omitempty"`
+
+	// Spec of the created Instances
+	Spec InstanceSpec `json:"spec"`
+}
+
+// InstanceSetSpec defines the desired state of InstanceSet
+type InstanceSetSpec struct {
+	// Replicas is the number of Instances that should be kept running
+	// +kubebuilder:validation:Minimum=0
+	Replicas int32 `json:"replicas"`
+
+	// Template describes the Instances that will be created
+	Template InstanceTemplateSpec `json:"template"`
+}
+
+// InstanceSetStatus defines the observed state of InstanceSet
+type InstanceSetStatus struct {
+	// Replicas is the number of Instances owned by the set that are not ending
+	Replicas int32 `json:"replicas"`
+
+	// ReadyReplicas is the number of Running Instances owned by the set
+	ReadyReplicas int32 `json:"readyReplicas"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:categories=cow
+// +kubebuilder:subresource:scale:specpath=.spec.replicas,statuspath=.status.replicas
+
+// InstanceSet is the Schema for the instancesets API
+type InstanceSet struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   InstanceSetSpec   `json:"spec,omitempty"`
+	Status InstanceSetStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// InstanceSetList contains a list of InstanceSet
+type InstanceSetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []InstanceSet `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&InstanceSet{}, &InstanceSetList{})
+}
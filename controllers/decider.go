@@ -6,10 +6,12 @@ import (
 	instancev1 "github.com/cownetwork/instance-controller/api/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// Action is what the InstanceReconciler does with an Instance
 type Action int
 
 const (
@@ -25,42 +27,267 @@ const (
 
 	// actionIgnore tells the controller to do nothing and ignore the request
 	ActionIgnore
+
+	// actionFinalize tells the controller to gracefully tear down
+	// the deleted Instance and release its finalizer
+	ActionFinalize
+
+	// actionRestart tells the controller to recreate the dead pod of the Instance
+	ActionRestart
+
+	// actionWait tells the controller to wait until the pod of the Instance,
+	// which exists but is missing in the cache, has been observed
+	ActionWait
 )
 
-type Decider struct {
+// Decider decides what the InstanceReconciler needs to do with an Instance
+type Decider interface {
+	Decide(ctx context.Context, instance instancev1.Instance, req ctrl.Request) (Action, error)
+}
+
+// Observation is the observed state of an Instance a Rule decides on
+type Observation struct {
+	Instance *instancev1.Instance
+
+	// Pod is the primary pod of the Instance or nil if it does not exist.
+	// The Instance is lost once it is gone.
+	Pod *corev1.Pod
+
+	// Companions are the existing companion pods of the Instance by their name in spec.pods
+	Companions map[string]*corev1.Pod
+
+	// Duplicates are the other pods owned by the Instance
+	Duplicates []corev1.Pod
+}
+
+// Rule decides what to do with an Instance in a specific situation.
+// ok is false if the rule does not apply to the observation.
+type Rule interface {
+	Decide(o Observation) (action Action, ok bool)
+}
+
+// RuleFunc is a function implementing Rule
+type RuleFunc func(o Observation) (Action, bool)
+
+// Decide implements Rule
+func (f RuleFunc) Decide(o Observation) (Action, bool) {
+	return f(o)
+}
+
+// RuleDecider observes the pod of an Instance and consults its Rules in order.
+// The first rule that applies decides, the Instance is ignored if no rule applies.
+// Pods are found using the .metadata.controller index of the InstanceReconciler.
+// A pod missing in the cache is looked up using the APIReader before the rules are
+// consulted, because a pod that has just been created may not be in the cache yet.
+type RuleDecider struct {
 	Client client.Client
+	Rules  []Rule
+
+	// APIReader reads pods missing in the cache from the API server.
+	// The cache is trusted if it is nil.
+	APIReader client.Reader
 }
 
-// decide decides based on the instance annotations, status and owned resource(s) what the controller needs to to do.
-func (d *Decider) Decide(ctx context.Context, instance instancev1.Instance, req ctrl.Request) (Action, error) {
-	idstr := instance.Status.ID
-	pod := &corev1.Pod{}
+var _ Decider = &RuleDecider{}
+
+// NewDefaultDecider creates a RuleDecider using the DefaultRules.
+// Custom policies can be composed by adding rules in front of or replacing the default ones.
+func NewDefaultDecider(c client.Client, apiReader client.Reader) *RuleDecider {
+	return &RuleDecider{Client: c, APIReader: apiReader, Rules: DefaultRules()}
+}
 
-	err := d.Client.Get(ctx, client.ObjectKey{Name: idstr, Namespace: instance.Namespace}, pod)
-	if err != nil && !apierrors.IsNotFound(err) {
+// DefaultRules returns the rules deciding the lifecycle of Instances described in
+// api/v1/instance_types.go, in the order they need to be consulted
+func DefaultRules() []Rule {
+	return []Rule{FinalizeRule, InitRule, RestartRule, CleanupRule, UpdateRule}
+}
+
+// Decide decides based on the instance status and owned pod what the controller needs to do
+func (d *RuleDecider) Decide(ctx context.Context, instance instancev1.Instance, req ctrl.Request) (Action, error) {
+	pods, err := getPods(ctx, d.Client, &instance)
+	if err != nil {
 		return -1, err
 	}
 
-	// If no state is set an no pod could be found
-	// the instance is completely new and a pod needs
-	// to be created
-	if len(string(instance.Status.State)) == 0 {
-		return ActionInit, nil
+	// The instance would be taken for lost if we decided without
+	// the pod we just created or restarted
+	if pods.Primary == nil && len(instance.Status.State) > 0 && instance.DeletionTimestamp == nil {
+		exists, err := d.podExists(ctx, &instance)
+		if err != nil {
+			return -1, err
+		}
+		if exists {
+			return ActionWait, nil
+		}
 	}
 
-	// If we id not find a pod, but the State field is set
-	// this means the pod corresponding to the instance has been deleted.
-	// This means we can cleanup this Instance.
-	if err != nil && apierrors.IsNotFound(err) {
-		return ActionCleanup, nil
+	o := Observation{
+		Instance:   &instance,
+		Pod:        pods.Primary,
+		Companions: pods.Companions,
+		Duplicates: pods.Duplicates,
 	}
 
-	// If the instance is still Initializing but we have a pod
-	// update the instance definition because we need to know
-	// the pods IP for example
-	if instance.Status.State == instancev1.StateInitializing {
-		return ActionUpdate, nil
+	for _, rule := range d.Rules {
+		if action, ok := rule.Decide(o); ok {
+			return action, nil
+		}
 	}
-
 	return ActionIgnore, nil
 }
+
+// podExists reports whether the pod of the instance exists according to the API server
+func (d *RuleDecider) podExists(ctx context.Context, instance *instancev1.Instance) (bool, error) {
+	name := podName(instance)
+	if d.APIReader == nil || len(name) == 0 {
+		return false, nil
+	}
+
+	var pod corev1.Pod
+	if err := d.APIReader.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: name}, &pod); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return metav1.IsControlledBy(&pod, instance), nil
+}
+
+// FinalizeRule tears down deleted instances before our finalizer can be removed
+var FinalizeRule RuleFunc = func(o Observation) (Action, bool) {
+	if o.Instance.DeletionTimestamp == nil {
+		return 0, false
+	}
+	if !hasFinalizer(o.Instance) {
+		return ActionIgnore, true
+	}
+	return ActionFinalize, true
+}
+
+// InitRule initializes instances without a state, they are completely new
+// or their pod could not be created yet
+var InitRule RuleFunc = func(o Observation) (Action, bool) {
+	if len(string(o.Instance.Status.State)) == 0 {
+		return ActionInit, true
+	}
+	return 0, false
+}
+
+// RestartRule recreates the pod once it died, as long as
+// the restart policy of the instance allows it
+var RestartRule RuleFunc = func(o Observation) (Action, bool) {
+	if o.Pod != nil && !podTerminated(o.Pod) {
+		return 0, false
+	}
+	failed := o.Pod == nil || o.Pod.Status.Phase == corev1.PodFailed
+	if shouldRestart(*o.Instance, failed) {
+		return ActionRestart, true
+	}
+	return 0, false
+}
+
+// CleanupRule removes instances whose pod has been deleted, and ending instances whose
+// pod terminated and is not restarted. The ended event has been emitted once they are ending.
+var CleanupRule RuleFunc = func(o Observation) (Action, bool) {
+	if o.Pod == nil || podTerminated(o.Pod) && o.Instance.Status.State == instancev1.StateEnding {
+		return ActionCleanup, true
+	}
+	return 0, false
+}
+
+// UpdateRule updates the status of the instance if the pod progressed since
+// we last looked at it, because we need to know the pods IP, node and state
+var UpdateRule RuleFunc = func(o Observation) (Action, bool) {
+	pod, status := o.Pod, o.Instance.Status
+	if pod == nil {
+		return 0, false
+	}
+	if podState(pod, status.State) != status.State ||
+		pod.Status.PodIP != status.IP ||
+		pod.Spec.NodeName != status.NodeName {
+		return ActionUpdate, true
+	}
+	return 0, false
+}
+
+// shouldRestart reports whether the restart policy of the instance allows
+// to recreate its pod. failed is set if the pod did not succeed.
+func shouldRestart(instance instancev1.Instance, failed bool) bool {
+	spec := instance.Spec
+	if spec.MaxRestarts != nil && instance.Status.RestartCount >= *spec.MaxRestarts {
+		return false
+	}
+
+	switch spec.RestartPolicy {
+	case instancev1.RestartPolicyAlways:
+		return true
+	case instancev1.RestartPolicyOnFailure:
+		return failed
+	}
+	return false
+}
+
+func podTerminated(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+// podState maps the phase and readiness of the pod to the state of the Instance.
+// The state only ever advances from Initializing to Running to Ending, a pod
+// that becomes unready again does not move a Running instance back.
+func podState(pod *corev1.Pod, current instancev1.InstanceState) instancev1.InstanceState {
+	state := instancev1.StateInitializing
+	switch {
+	case pod.DeletionTimestamp != nil,
+		pod.Status.Phase == corev1.PodSucceeded,
+		pod.Status.Phase == corev1.PodFailed:
+		state = instancev1.StateEnding
+	case pod.Status.Phase == corev1.PodRunning && podReady(pod):
+		state = instancev1.StateRunning
+	}
+
+	if stateRank(state) < stateRank(current) {
+		return current
+	}
+	return state
+}
+
+// podReady reports whether the pod is ready to accept players. This is the case if the pod
+// is Ready, all of its containers are ready and all of its readiness gates are fulfilled.
+func podReady(pod *corev1.Pod) bool {
+	conditions := make(map[corev1.PodConditionType]corev1.ConditionStatus)
+	for _, c := range pod.Status.Conditions {
+		conditions[c.Type] = c.Status
+	}
+
+	if conditions[corev1.PodReady] != corev1.ConditionTrue {
+		return false
+	}
+
+	for _, gate := range pod.Spec.ReadinessGates {
+		if conditions[gate.ConditionType] != corev1.ConditionTrue {
+			return false
+		}
+	}
+
+	if len(pod.Status.ContainerStatuses) == 0 {
+		return false
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if !cs.Ready {
+			return false
+		}
+	}
+	return true
+}
+
+func stateRank(state instancev1.InstanceState) int {
+	switch state {
+	case instancev1.StateInitializing:
+		return 1
+	case instancev1.StateRunning:
+		return 2
+	case instancev1.StateEnding:
+		return 3
+	}
+	return 0
+}
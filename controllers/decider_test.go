@@ -0,0 +1,291 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	instancev1 "github.com/cownetwork/instance-controller/api/v1"
+)
+
+func TestRuleDeciderComposition(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = instancev1.AddToScheme(scheme)
+
+	// lobbies are always kept alive, no matter their restart policy
+	keepAlive := RuleFunc(func(o Observation) (Action, bool) {
+		if o.Instance.Labels["mode"] == "lobby" && (o.Pod == nil || podTerminated(o.Pod)) {
+			return ActionRestart, true
+		}
+		return 0, false
+	})
+	decider := &RuleDecider{
+		Client: fake.NewFakeClientWithScheme(scheme),
+		Rules:  append([]Rule{keepAlive}, DefaultRules()...),
+	}
+
+	for mode, want := range map[string]Action{"lobby": ActionRestart, "game": ActionCleanup} {
+		instance := instancev1.Instance{}
+		instance.Name = mode
+		instance.Namespace = "default"
+		instance.Labels = map[string]string{"mode": mode}
+		instance.Status.ID = "gone"
+		instance.Status.State = instancev1.StateRunning
+
+		got, err := decider.Decide(context.Background(), instance, ctrl.Request{})
+		if err != nil {
+			t.Fatalf("Decide() error = %v", err)
+		}
+		if got != want {
+			t.Errorf("Decide() for %s = %v, want %v", mode, got, want)
+		}
+	}
+
+	// rules that don't apply leave the decision to the next rule
+	if action, ok := UpdateRule.Decide(Observation{Instance: &instancev1.Instance{}}); ok {
+		t.Errorf("UpdateRule applied without a pod: %v", action)
+	}
+}
+
+func TestDefaultDecider(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = instancev1.AddToScheme(scheme)
+
+	const id = "b3c6d2ca-1b1a-4a47-a3a4-52d7a0a5a8c3"
+	now := metav1.Now()
+	always := func(i *instancev1.Instance) { i.Spec.RestartPolicy = instancev1.RestartPolicyAlways }
+	onFailure := func(i *instancev1.Instance) { i.Spec.RestartPolicy = instancev1.RestartPolicyOnFailure }
+	running := func(i *instancev1.Instance) {
+		i.Status.State = instancev1.StateRunning
+		i.Status.IP = "10.0.0.1"
+		i.Status.NodeName = "node-1"
+	}
+	readyPod := func(p *corev1.Pod) {
+		p.Spec.NodeName = "node-1"
+		p.Status.Phase = corev1.PodRunning
+		p.Status.PodIP = "10.0.0.1"
+		p.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		p.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: "game", Ready: true}}
+	}
+	phase := func(phase corev1.PodPhase) func(p *corev1.Pod) {
+		return func(p *corev1.Pod) { p.Status.Phase = phase }
+	}
+
+	tests := []struct {
+		name     string
+		instance []func(*instancev1.Instance)
+		// pod is nil if the instance has no pod
+		pod []func(*corev1.Pod)
+		// uncached is set if the pod is not in the cache yet
+		uncached bool
+		want     Action
+	}{
+		{
+			name: "new instance",
+			want: ActionInit,
+		},
+		{
+			name:     "pod not scheduled yet",
+			instance: []func(*instancev1.Instance){func(i *instancev1.Instance) { i.Status.State = instancev1.StateInitializing }},
+			pod:      []func(*corev1.Pod){phase(corev1.PodPending)},
+			want:     ActionIgnore,
+		},
+		{
+			name:     "pod scheduled",
+			instance: []func(*instancev1.Instance){func(i *instancev1.Instance) { i.Status.State = instancev1.StateInitializing }},
+			pod:      []func(*corev1.Pod){phase(corev1.PodPending), func(p *corev1.Pod) { p.Spec.NodeName = "node-1" }},
+			want:     ActionUpdate,
+		},
+		{
+			name:     "pod ready",
+			instance: []func(*instancev1.Instance){func(i *instancev1.Instance) { i.Status.State = instancev1.StateInitializing }},
+			pod:      []func(*corev1.Pod){readyPod},
+			want:     ActionUpdate,
+		},
+		{
+			name:     "pod up to date",
+			instance: []func(*instancev1.Instance){running},
+			pod:      []func(*corev1.Pod){readyPod},
+			want:     ActionIgnore,
+		},
+		{
+			name:     "pod got a new IP",
+			instance: []func(*instancev1.Instance){running},
+			pod:      []func(*corev1.Pod){readyPod, func(p *corev1.Pod) { p.Status.PodIP = "10.0.0.2" }},
+			want:     ActionUpdate,
+		},
+		{
+			name:     "pod unready again",
+			instance: []func(*instancev1.Instance){running},
+			pod:      []func(*corev1.Pod){readyPod, func(p *corev1.Pod) { p.Status.ContainerStatuses[0].Ready = false }},
+			want:     ActionIgnore,
+		},
+		{
+			name:     "pod succeeded",
+			instance: []func(*instancev1.Instance){running},
+			pod:      []func(*corev1.Pod){readyPod, phase(corev1.PodSucceeded)},
+			want:     ActionUpdate,
+		},
+		{
+			name:     "pod gone",
+			instance: []func(*instancev1.Instance){running},
+			want:     ActionCleanup,
+		},
+		{
+			name:     "pod gone, restart always",
+			instance: []func(*instancev1.Instance){running, always},
+			want:     ActionRestart,
+		},
+		{
+			name:     "pod just created, not in the cache yet",
+			instance: []func(*instancev1.Instance){func(i *instancev1.Instance) { i.Status.State = instancev1.StateInitializing }},
+			pod:      []func(*corev1.Pod){phase(corev1.PodPending)},
+			uncached: true,
+			want:     ActionWait,
+		},
+		{
+			name: "pod just restarted, not in the cache yet",
+			instance: []func(*instancev1.Instance){always, func(i *instancev1.Instance) {
+				i.Status.State = instancev1.StateInitializing
+				i.Status.RestartCount = 1
+			}},
+			pod:      []func(*corev1.Pod){phase(corev1.PodPending)},
+			uncached: true,
+			want:     ActionWait,
+		},
+		{
+			name:     "pod succeeded, restart always",
+			instance: []func(*instancev1.Instance){running, always},
+			pod:      []func(*corev1.Pod){readyPod, phase(corev1.PodSucceeded)},
+			want:     ActionRestart,
+		},
+		{
+			name:     "pod succeeded, restart on failure",
+			instance: []func(*instancev1.Instance){running, onFailure},
+			pod:      []func(*corev1.Pod){readyPod, phase(corev1.PodSucceeded)},
+			want:     ActionUpdate,
+		},
+		{
+			name: "pod succeeded and ended, restart on failure",
+			instance: []func(*instancev1.Instance){running, onFailure, func(i *instancev1.Instance) {
+				i.Status.State = instancev1.StateEnding
+			}},
+			pod:  []func(*corev1.Pod){readyPod, phase(corev1.PodSucceeded)},
+			want: ActionCleanup,
+		},
+		{
+			name: "pod failed and ended, restart never",
+			instance: []func(*instancev1.Instance){running, func(i *instancev1.Instance) {
+				i.Status.State = instancev1.StateEnding
+			}},
+			pod:  []func(*corev1.Pod){readyPod, phase(corev1.PodFailed)},
+			want: ActionCleanup,
+		},
+		{
+			name:     "pod failed, restart on failure",
+			instance: []func(*instancev1.Instance){running, onFailure},
+			pod:      []func(*corev1.Pod){readyPod, phase(corev1.PodFailed)},
+			want:     ActionRestart,
+		},
+		{
+			name: "pod gone, restarts exhausted",
+			instance: []func(*instancev1.Instance){running, always, func(i *instancev1.Instance) {
+				max := int32(3)
+				i.Spec.MaxRestarts = &max
+				i.Status.RestartCount = 3
+			}},
+			want: ActionCleanup,
+		},
+		{
+			name: "deleted",
+			instance: []func(*instancev1.Instance){running, func(i *instancev1.Instance) {
+				i.DeletionTimestamp = &now
+				i.Finalizers = []string{instancev1.InstanceFinalizer}
+			}},
+			pod:  []func(*corev1.Pod){readyPod},
+			want: ActionFinalize,
+		},
+		{
+			name: "deleted, finalized",
+			instance: []func(*instancev1.Instance){running, func(i *instancev1.Instance) {
+				i.DeletionTimestamp = &now
+			}},
+			want: ActionIgnore,
+		},
+		{
+			name:     "pod named differently",
+			instance: []func(*instancev1.Instance){running, func(i *instancev1.Instance) { i.Status.PodName = "game-0" }},
+			pod:      []func(*corev1.Pod){readyPod, func(p *corev1.Pod) { p.Name = "game-0" }},
+			want:     ActionIgnore,
+		},
+		{
+			name: "companion pod gone",
+			instance: []func(*instancev1.Instance){running, func(i *instancev1.Instance) {
+				i.Spec.Pods = []instancev1.InstancePodTemplate{{Name: "voice"}}
+			}},
+			pod:  []func(*corev1.Pod){readyPod},
+			want: ActionIgnore,
+		},
+		{
+			name:     "pod not owned by the instance",
+			instance: []func(*instancev1.Instance){running},
+			pod:      []func(*corev1.Pod){readyPod, func(p *corev1.Pod) { p.OwnerReferences = nil }},
+			want:     ActionCleanup,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := instancev1.Instance{}
+			instance.Name = "test"
+			instance.Namespace = "default"
+			instance.UID = types.UID(id)
+			instance.Status.ID = id
+			for _, mutate := range tt.instance {
+				mutate(&instance)
+			}
+			if len(instance.Status.State) == 0 {
+				instance.Status.ID = ""
+			}
+
+			var objs []runtime.Object
+			if tt.pod != nil {
+				pod := &corev1.Pod{}
+				pod.Name = id
+				pod.Namespace = "default"
+				if err := ctrl.SetControllerReference(&instance, pod, scheme); err != nil {
+					t.Fatal(err)
+				}
+				for _, mutate := range tt.pod {
+					mutate(pod)
+				}
+				objs = append(objs, pod)
+			}
+
+			cached := objs
+			if tt.uncached {
+				cached = nil
+			}
+			decider := NewDefaultDecider(
+				fake.NewFakeClientWithScheme(scheme, cached...),
+				fake.NewFakeClientWithScheme(scheme, objs...),
+			)
+			got, err := decider.Decide(context.Background(), instance, ctrl.Request{})
+			if err != nil {
+				t.Fatalf("Decide() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Decide() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
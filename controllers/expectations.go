@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	instancev1 "github.com/cownetwork/instance-controller/api/v1"
+)
+
+// expectationsTimeout is the time after which expectations that were never observed
+// are given up on. It matches the timeout of the ReplicaSet controller.
+const expectationsTimeout = 5 * time.Minute
+
+// expectations tracks the Instances a controller created or deleted until the changes
+// show up in the cache. Scaling based on a cache that does not contain them yet would
+// create or delete Instances twice. The zero value is ready to use.
+type expectations struct {
+	mu      sync.Mutex
+	pending map[types.NamespacedName]*expectation
+}
+
+// expectation are the pending changes of a single owner
+type expectation struct {
+	// creates are the names of created Instances that have not been observed yet
+	creates map[string]struct{}
+
+	// deletes are the UIDs of deleted Instances that are still observed without a deletion timestamp
+	deletes map[types.UID]struct{}
+
+	// timestamp is the time of the last change
+	timestamp time.Time
+}
+
+// get returns the expectation of the owner, creating it if necessary
+func (e *expectations) get(key types.NamespacedName) *expectation {
+	if e.pending == nil {
+		e.pending = make(map[types.NamespacedName]*expectation)
+	}
+	exp, ok := e.pending[key]
+	if !ok {
+		exp = &expectation{creates: make(map[string]struct{}), deletes: make(map[types.UID]struct{})}
+		e.pending[key] = exp
+	}
+	exp.timestamp = time.Now()
+	return exp
+}
+
+// expectCreate records that the owner created the Instance with the given name
+func (e *expectations) expectCreate(key types.NamespacedName, name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.get(key).creates[name] = struct{}{}
+}
+
+// expectDelete records that the owner deleted the Instance with the given UID
+func (e *expectations) expectDelete(key types.NamespacedName, uid types.UID) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.get(key).deletes[uid] = struct{}{}
+}
+
+// satisfied observes the Instances of the owner listed from the cache and reports whether
+// all of its creations and deletions have been observed, or the expectations expired.
+func (e *expectations) satisfied(key types.NamespacedName, instances []instancev1.Instance) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	exp, ok := e.pending[key]
+	if !ok {
+		return true
+	}
+
+	present := make(map[types.UID]bool, len(instances))
+	for _, instance := range instances {
+		delete(exp.creates, instance.Name)
+		present[instance.UID] = instance.DeletionTimestamp == nil
+	}
+	for uid := range exp.deletes {
+		if !present[uid] {
+			delete(exp.deletes, uid)
+		}
+	}
+
+	if len(exp.creates) == 0 && len(exp.deletes) == 0 || time.Since(exp.timestamp) > expectationsTimeout {
+		delete(e.pending, key)
+		return true
+	}
+	return false
+}
+
+// forget removes the expectations of the owner
+func (e *expectations) forget(key types.NamespacedName) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.pending, key)
+}
@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	instancev1 "github.com/cownetwork/instance-controller/api/v1"
+)
+
+func TestExpectations(t *testing.T) {
+	key := types.NamespacedName{Namespace: "default", Name: "lobby"}
+	var e expectations
+	if !e.satisfied(key, nil) {
+		t.Fatal("satisfied() = false without expectations")
+	}
+
+	kept := instancev1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "lobby-a", UID: "a"}}
+	deleted := instancev1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "lobby-b", UID: "b"}}
+	e.expectCreate(key, "lobby-c")
+	e.expectDelete(key, deleted.UID)
+
+	// the cache has seen neither the creation nor the deletion
+	if e.satisfied(key, []instancev1.Instance{kept, deleted}) {
+		t.Error("satisfied() = true before the changes were observed")
+	}
+
+	created := instancev1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "lobby-c", UID: "c"}}
+	if e.satisfied(key, []instancev1.Instance{kept, deleted, created}) {
+		t.Error("satisfied() = true before the deletion was observed")
+	}
+
+	now := metav1.Now()
+	deleted.DeletionTimestamp = &now
+	if !e.satisfied(key, []instancev1.Instance{kept, deleted, created}) {
+		t.Error("satisfied() = false after all changes were observed")
+	}
+
+	e.expectCreate(key, "lobby-d")
+	e.pending[key].timestamp = time.Now().Add(-expectationsTimeout - time.Second)
+	if !e.satisfied(key, nil) {
+		t.Error("satisfied() = false after the expectations expired")
+	}
+}
@@ -0,0 +1,288 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	instancev1 "github.com/cownetwork/instance-controller/api/v1"
+	"github.com/cownetwork/instance-controller/event"
+)
+
+// updateConditions derives the player count, free slots, companion pod status and
+// all conditions of the instance from its pods, players and allocation
+func (r *InstanceReconciler) updateConditions(ctx context.Context, instance *instancev1.Instance) error {
+	pods, err := getPods(ctx, r, instance)
+	if err != nil {
+		return err
+	}
+
+	before := instance.DeepCopy()
+	instance.Status.PlayerCount = int32(len(instance.Status.Metadata.Players))
+	instance.Status.Pods = companionPodStatuses(instance, pods.Companions)
+	conditions := []instancev1.InstanceCondition{
+		podScheduledCondition(pods.Primary),
+		readyCondition(instance, pods.Primary, pods.Companions),
+		allocatedCondition(instance),
+		duplicatePodsCondition(pods.Duplicates),
+	}
+	if full, ok := computeCapacity(instance); ok {
+		conditions = append(conditions, full)
+	}
+	if instance.DeletionTimestamp == nil {
+		conditions = append(conditions, instancev1.InstanceCondition{
+			Type:    instancev1.InstanceDraining,
+			Status:  corev1.ConditionFalse,
+			Reason:  "NotDeleted",
+			Message: "The instance has not been deleted",
+		})
+	}
+	transitioned := applyConditions(instance, conditions)
+	return r.patchConditions(ctx, before, instance, transitioned)
+}
+
+// setConditions sets the conditions on the status of the instance and patches it if anything changed
+func (r *InstanceReconciler) setConditions(
+	ctx context.Context,
+	instance *instancev1.Instance,
+	conditions ...instancev1.InstanceCondition,
+) error {
+	before := instance.DeepCopy()
+	transitioned := applyConditions(instance, conditions)
+	return r.patchConditions(ctx, before, instance, transitioned)
+}
+
+// applyConditions sets the conditions on the status of the instance and
+// returns the ones that have been added or changed their status
+func applyConditions(instance *instancev1.Instance, conditions []instancev1.InstanceCondition) []instancev1.InstanceCondition {
+	var transitioned []instancev1.InstanceCondition
+	for _, condition := range conditions {
+		condition.ObservedGeneration = instance.Generation
+		if instance.Status.SetCondition(condition) {
+			transitioned = append(transitioned, condition)
+		}
+	}
+	return transitioned
+}
+
+// patchConditions writes the status of the instance if it differs from the one of before.
+// Every transitioned condition is recorded as Kubernetes event.
+func (r *InstanceReconciler) patchConditions(
+	ctx context.Context,
+	before, instance *instancev1.Instance,
+	transitioned []instancev1.InstanceCondition,
+) error {
+	if apiequality.Semantic.DeepEqual(before.Status, instance.Status) {
+		return nil
+	}
+	if err := r.Status().Patch(ctx, instance, client.MergeFrom(before)); err != nil {
+		return err
+	}
+
+	for _, condition := range transitioned {
+		eventtype := corev1.EventTypeNormal
+		if isWarning(condition) {
+			eventtype = corev1.EventTypeWarning
+		}
+		r.event(instance, eventtype, condition.Reason, "%s is %s: %s", condition.Type, condition.Status, condition.Message)
+	}
+	return nil
+}
+
+// emit sends an event of the given type using send and records the outcome in the
+// EventsDelivered condition. An event queued for redelivery has not been delivered yet.
+// Nothing is sent if events are disabled.
+func (r *InstanceReconciler) emit(ctx context.Context, log logr.Logger, instance *instancev1.Instance, eventtype string, send func() error) {
+	if r.Emitter == nil {
+		return
+	}
+
+	condition := instancev1.InstanceCondition{
+		Type:    instancev1.InstanceEventsDelivered,
+		Status:  corev1.ConditionTrue,
+		Reason:  "Delivered",
+		Message: "Events of the instance are delivered",
+	}
+	err := send()
+	switch {
+	case err == nil:
+		eventsEmitted.WithLabelValues(eventtype, "success").Inc()
+	case errors.Is(err, event.ErrQueued):
+		log.Info("event queued for redelivery", "instance_id", instance.Status.ID, "event_type", eventtype, "error", err.Error())
+		eventsEmitted.WithLabelValues(eventtype, "queued").Inc()
+		condition.Status = corev1.ConditionFalse
+		condition.Reason = "DeliveryPending"
+		condition.Message = fmt.Sprintf("Could not deliver %s event, it is queued for redelivery: %v", eventtype, err)
+		r.event(instance, corev1.EventTypeWarning, "EventDeliveryFailed", "Could not deliver %s event, it is queued for redelivery: %v", eventtype, err)
+	default:
+		log.Error(err, "could not emit event", "instance_id", instance.Status.ID, "event_type", eventtype)
+		eventsEmitted.WithLabelValues(eventtype, "failure").Inc()
+		condition.Status = corev1.ConditionFalse
+		condition.Reason = "DeliveryFailed"
+		condition.Message = fmt.Sprintf("Could not emit %s event: %v", eventtype, err)
+		r.event(instance, corev1.EventTypeWarning, "EventDeliveryFailed", "Could not emit %s event: %v", eventtype, err)
+	}
+
+	// the instance is gone once the finalizer has been removed
+	if err := r.setConditions(ctx, instance, condition); client.IgnoreNotFound(err) != nil {
+		log.Error(err, "could not update EventsDelivered condition", "instance_id", instance.Status.ID)
+	}
+}
+
+// isWarning reports whether the condition describes a problem
+func isWarning(condition instancev1.InstanceCondition) bool {
+	switch condition.Type {
+	case instancev1.InstancePodScheduled, instancev1.InstanceEventsDelivered:
+		return condition.Status == corev1.ConditionFalse
+	case instancev1.InstanceDuplicatePods:
+		return condition.Status == corev1.ConditionTrue
+	}
+	return false
+}
+
+func podScheduledCondition(pod *corev1.Pod) instancev1.InstanceCondition {
+	condition := instancev1.InstanceCondition{Type: instancev1.InstancePodScheduled}
+	if pod == nil {
+		condition.Status = corev1.ConditionFalse
+		condition.Reason = "PodMissing"
+		condition.Message = "The pod of the instance does not exist"
+		return condition
+	}
+
+	condition.Status = corev1.ConditionUnknown
+	condition.Reason = "Pending"
+	condition.Message = "The pod has not been considered by the scheduler yet"
+	for _, c := range pod.Status.Conditions {
+		if c.Type != corev1.PodScheduled {
+			continue
+		}
+		condition.Status = c.Status
+		condition.Reason = c.Reason
+		condition.Message = c.Message
+		if c.Status == corev1.ConditionTrue {
+			condition.Reason = "Scheduled"
+			condition.Message = fmt.Sprintf("The pod has been scheduled to %s", pod.Spec.NodeName)
+		}
+		if len(condition.Reason) == 0 {
+			condition.Reason = "Pending"
+		}
+	}
+	return condition
+}
+
+func readyCondition(instance *instancev1.Instance, pod *corev1.Pod, companions map[string]*corev1.Pod) instancev1.InstanceCondition {
+	condition := instancev1.InstanceCondition{
+		Type:   instancev1.InstanceReady,
+		Status: corev1.ConditionFalse,
+	}
+	notReady := notReadyCompanions(instance, companions)
+	switch {
+	case pod == nil:
+		condition.Reason = "PodMissing"
+		condition.Message = "The pod of the instance does not exist"
+	case instance.Status.State == instancev1.StateEnding:
+		condition.Reason = "Ending"
+		condition.Message = "The instance is ending"
+	case instance.Status.State != instancev1.StateRunning:
+		condition.Reason = "Initializing"
+		condition.Message = "The instance is initializing"
+	case !podReady(pod):
+		condition.Reason = "PodNotReady"
+		condition.Message = "The pod of the instance is not ready"
+	case len(notReady) > 0:
+		condition.Reason = "CompanionPodsNotReady"
+		condition.Message = fmt.Sprintf("The companion pods %s are not ready", strings.Join(notReady, ", "))
+	default:
+		condition.Status = corev1.ConditionTrue
+		condition.Reason = "PodReady"
+		condition.Message = "The instance is running and its pod is ready"
+	}
+	return condition
+}
+
+// notReadyCompanions returns the names of the companion pods that do not exist or are not ready
+func notReadyCompanions(instance *instancev1.Instance, companions map[string]*corev1.Pod) []string {
+	var names []string
+	for _, template := range instance.Spec.Pods {
+		if pod := companions[template.Name]; pod == nil || !podReady(pod) {
+			names = append(names, template.Name)
+		}
+	}
+	return names
+}
+
+func allocatedCondition(instance *instancev1.Instance) instancev1.InstanceCondition {
+	if allocation := instance.Status.Allocation; allocation != nil {
+		return instancev1.InstanceCondition{
+			Type:    instancev1.InstanceAllocated,
+			Status:  corev1.ConditionTrue,
+			Reason:  "Allocated",
+			Message: fmt.Sprintf("The instance has been allocated by %s", allocation.Name),
+		}
+	}
+	return instancev1.InstanceCondition{
+		Type:    instancev1.InstanceAllocated,
+		Status:  corev1.ConditionFalse,
+		Reason:  "NotAllocated",
+		Message: "The instance has not been allocated",
+	}
+}
+
+func duplicatePodsCondition(duplicates []corev1.Pod) instancev1.InstanceCondition {
+	if len(duplicates) == 0 {
+		return instancev1.InstanceCondition{
+			Type:    instancev1.InstanceDuplicatePods,
+			Status:  corev1.ConditionFalse,
+			Reason:  "NoDuplicates",
+			Message: "The instance owns no other pods besides its own",
+		}
+	}
+
+	names := make([]string, 0, len(duplicates))
+	for _, pod := range duplicates {
+		names = append(names, pod.Name)
+	}
+	return instancev1.InstanceCondition{
+		Type:    instancev1.InstanceDuplicatePods,
+		Status:  corev1.ConditionTrue,
+		Reason:  "DuplicatePods",
+		Message: fmt.Sprintf("The instance owns %d duplicate pods: %s", len(duplicates), strings.Join(names, ", ")),
+	}
+}
+
+// computeCapacity derives the free slots of the instance from its capacity and
+// returns the Full condition. ok is false if the instance has no capacity.
+func computeCapacity(instance *instancev1.Instance) (full instancev1.InstanceCondition, ok bool) {
+	status := &instance.Status
+	capacity := instance.Spec.Capacity
+	if capacity == nil {
+		status.FreeSlots = nil
+		status.RemoveCondition(instancev1.InstanceFull)
+		return full, false
+	}
+
+	free := capacity.MaxPlayers - capacity.ReservedSlots - status.PlayerCount
+	if free < 0 {
+		free = 0
+	}
+	status.FreeSlots = &free
+
+	full = instancev1.InstanceCondition{
+		Type:    instancev1.InstanceFull,
+		Status:  corev1.ConditionFalse,
+		Reason:  "FreeSlots",
+		Message: fmt.Sprintf("%d free slots", free),
+	}
+	if free == 0 {
+		full.Status = corev1.ConditionTrue
+		full.Reason = "NoFreeSlots"
+		full.Message = fmt.Sprintf("%d of %d players connected", status.PlayerCount, capacity.MaxPlayers)
+	}
+	return full, true
+}
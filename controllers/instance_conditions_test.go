@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	instancev1 "github.com/cownetwork/instance-controller/api/v1"
+	"github.com/cownetwork/instance-controller/event"
+)
+
+// useOutbox lets r emit events through an Outbox whose sink is unavailable
+func useOutbox(r *InstanceReconciler) (*event.MemorySink, *event.ConfigMapStore) {
+	sink := &event.MemorySink{Err: errors.New("broker unavailable")}
+	store := event.NewConfigMapStore(
+		fake.NewFakeClientWithScheme(r.Scheme),
+		types.NamespacedName{Namespace: "default", Name: "outbox"},
+	)
+	r.Emitter = event.NewEmitter(event.NewOutbox(sink, store, r.Log), "test")
+	return sink, store
+}
+
+func TestEmitQueuedEvent(t *testing.T) {
+	ctx := context.Background()
+	r, instance := newPodTestReconciler()
+	instance.Status.ID = string(instance.UID)
+
+	sink, store := useOutbox(r)
+
+	queued := testutil.ToFloat64(eventsEmitted.WithLabelValues(event.TypeInstanceStarted, "queued"))
+	r.emitStarted(ctx, r.Log, instance)
+	if got := testutil.ToFloat64(eventsEmitted.WithLabelValues(event.TypeInstanceStarted, "queued")); got != queued+1 {
+		t.Errorf("counted %v queued events, want 1", got-queued)
+	}
+	condition := instance.Status.Condition(instancev1.InstanceEventsDelivered)
+	if condition == nil || condition.Status != corev1.ConditionFalse || condition.Reason != "DeliveryPending" {
+		t.Fatalf("condition %s = %+v, want False because the event is queued", instancev1.InstanceEventsDelivered, condition)
+	}
+	if pending, err := store.List(ctx); err != nil || len(pending) != 1 {
+		t.Errorf("List() = %d pending events, %v, want the queued event", len(pending), err)
+	}
+
+	sink.Err = nil
+	r.emitStarted(ctx, r.Log, instance)
+	condition = instance.Status.Condition(instancev1.InstanceEventsDelivered)
+	if condition == nil || condition.Status != corev1.ConditionTrue {
+		t.Errorf("condition %s = %+v, want True once events are delivered", instancev1.InstanceEventsDelivered, condition)
+	}
+}
+
+func TestEmitRecordsDeliveryFailure(t *testing.T) {
+	ctx := context.Background()
+	r, instance := newPodTestReconciler()
+	instance.Status.ID = string(instance.UID)
+	recorder := record.NewFakeRecorder(10)
+	r.Recorder = recorder
+
+	useOutbox(r)
+
+	r.emitStarted(ctx, r.Log, instance)
+	var reasons []string
+	for len(recorder.Events) > 0 {
+		reasons = append(reasons, <-recorder.Events)
+	}
+	for _, reason := range reasons {
+		if strings.HasPrefix(reason, corev1.EventTypeWarning+" EventDeliveryFailed ") {
+			return
+		}
+	}
+	t.Errorf("recorded events %q, want an EventDeliveryFailed warning", reasons)
+}
@@ -1,33 +1,66 @@
 package controllers
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
 
 	"github.com/go-logr/logr"
-	"github.com/google/uuid"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	instancev1 "github.com/cownetwork/instance-controller/api/v1"
+	"github.com/cownetwork/instance-controller/event"
+	"github.com/cownetwork/instance-controller/playerapi"
 )
 
 // TODO: use upspin like errors
 
+// controllerOwnerKey indexes owned objects by the name of their controller
+const controllerOwnerKey = ".metadata.controller"
+
+// The status of an Instance is shared between the controller and the application
+// running in the pod, which reports its metadata. The controller therefore only ever
+// writes the status using merge patches containing the fields it changed, so
+// concurrent writes to other fields are never overwritten with stale data.
+
 // InstanceReconciler reconciles a Instance object
 type InstanceReconciler struct {
 	client.Client
 	Log     logr.Logger
 	Scheme  *runtime.Scheme
 	Decider Decider
+
+	// Emitter publishes lifecycle events of the Instances.
+	// Events are disabled if it is nil.
+	Emitter *event.Emitter
+
+	// Recorder records Kubernetes events for the Instances.
+	// Events are not recorded if it is nil.
+	Recorder record.EventRecorder
+
+	// TokenSecret is used to derive the token the pods authenticate at the player API with.
+	// No token is handed to the pods if it is empty.
+	TokenSecret []byte
 }
 
 // +kubebuilder:rbac:groups=instance.cow.network,resources=instances,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=instance.cow.network,resources=instances/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=batch,resources=instances,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=batch,resources=instances/status,verbs=get
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 func (r *InstanceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
 	log := r.Log.WithValues("instance_name", req.Name, "namespace", req.Namespace)
@@ -39,6 +72,24 @@ func (r *InstanceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	// Every instance needs our finalizer to give the application
+	// a chance to shut down gracefully once it is deleted
+	if instance.DeletionTimestamp == nil && !hasFinalizer(&instance) {
+		patch := client.MergeFrom(instance.DeepCopy())
+		controllerutil.AddFinalizer(&instance, instancev1.InstanceFinalizer)
+		if err := r.Patch(ctx, &instance, patch); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Duplicate pods are deleted and missing companion pods are created once the instance is initialized
+	if len(instance.Status.State) > 0 {
+		if err := r.syncPods(ctx, &instance); err != nil {
+			log.Error(err, "could not sync pods")
+			return ctrl.Result{}, err
+		}
+	}
+
 	action, err := r.Decider.Decide(ctx, instance, req)
 	if err != nil {
 		return ctrl.Result{}, err // Maybe we need to requeue if no decision could be made due tue an error
@@ -51,6 +102,7 @@ func (r *InstanceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 			return ctrl.Result{}, err
 		}
 		log.Info("created Instance successfully", "instance_id", instance.Status.ID)
+		r.emitStarted(ctx, log, &instance)
 		break
 	case ActionCleanup:
 		logger := log.WithValues(
@@ -64,84 +116,503 @@ func (r *InstanceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		}
 		logger.Info("cleaned up Instance successfully")
 		break
+	case ActionFinalize:
+		logger := log.WithValues(
+			"instance_id", instance.Status.ID,
+			"instance_name", instance.Name,
+			"namespace", instance.Namespace,
+		)
+		requeue, err := r.finalizeInstance(ctx, logger, &instance)
+		if err != nil {
+			logger.Error(err, "could not finalize Instance")
+			return ctrl.Result{}, err
+		}
+		if requeue > 0 {
+			logger.Info("waiting for players to leave", "players", len(instance.Status.Metadata.Players))
+			return ctrl.Result{RequeueAfter: requeue}, nil
+		}
+		logger.Info("finalized Instance successfully")
+		break
+	case ActionRestart:
+		logger := log.WithValues(
+			"instance_id", instance.Status.ID,
+			"instance_name", instance.Name,
+			"namespace", instance.Namespace,
+		)
+		requeue, err := r.restartInstance(ctx, logger, &instance)
+		if err != nil {
+			logger.Error(err, "could not restart Instance")
+			return ctrl.Result{}, err
+		}
+		if requeue > 0 {
+			logger.Info("waiting to restart Instance", "restart_count", instance.Status.RestartCount, "backoff", requeue)
+			return ctrl.Result{RequeueAfter: requeue}, nil
+		}
+		break
 	case ActionUpdate:
 		logger := log.WithValues(
 			"instance_id", instance.Status.ID,
 			"instance_name", instance.Name,
 			"namespace", instance.Namespace,
 		)
+		previous := instance.Status.State
 		if err := r.updateInstance(ctx, &instance); err != nil {
 			logger.Error(err, "could not update Instance")
 			return ctrl.Result{}, err
 		}
-		logger.Info("updated Instance successfully")
+		logger.Info("updated Instance successfully", "state", instance.Status.State, "ip", instance.Status.IP)
+		if previous != instancev1.StateRunning && instance.Status.State == instancev1.StateRunning &&
+			instance.Status.RestartCount == 0 {
+			instanceStartupSeconds.WithLabelValues(instance.Namespace).Observe(time.Since(instance.CreationTimestamp.Time).Seconds())
+		}
+		if previous != instancev1.StateEnding && instance.Status.State == instancev1.StateEnding {
+			r.emitEnded(ctx, logger, &instance)
+		}
+		if err := r.updateConditions(ctx, &instance); err != nil {
+			logger.Error(err, "could not update conditions of Instance")
+			return ctrl.Result{}, err
+		}
+		if err := r.emitMetadataChanged(ctx, logger, &instance); err != nil {
+			logger.Error(err, "could not record observed metadata")
+			return ctrl.Result{}, err
+		}
+		break
+	case ActionWait:
+		// the pod event requeues the instance once the pod is in the cache
+		log.V(1).Info("waiting for the pod to be observed", "pod_name", podName(&instance))
 		break
 	case ActionIgnore:
+		if err := r.updateConditions(ctx, &instance); err != nil {
+			log.Error(err, "could not update conditions of Instance")
+			return ctrl.Result{}, err
+		}
+		if err := r.emitMetadataChanged(ctx, log, &instance); err != nil {
+			log.Error(err, "could not record observed metadata")
+			return ctrl.Result{}, err
+		}
 		break
 	}
 
 	return ctrl.Result{}, nil
 }
 
-func (r *InstanceReconciler) initInstance(ctx context.Context, instance *instancev1.Instance) error {
-	id, err := uuid.NewRandom()
+// event records a Kubernetes event for the instance
+func (r *InstanceReconciler) event(instance *instancev1.Instance, eventtype, reason, messageFmt string, args ...interface{}) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Eventf(instance, eventtype, reason, messageFmt, args...)
+}
+
+// emitStarted emits an InstanceStartedEvent
+func (r *InstanceReconciler) emitStarted(ctx context.Context, log logr.Logger, instance *instancev1.Instance) {
+	r.emit(ctx, log, instance, event.TypeInstanceStarted, func() error {
+		return r.Emitter.InstanceCreated(ctx, instance)
+	})
+}
+
+// emitEnded emits an InstanceEndedEvent
+func (r *InstanceReconciler) emitEnded(ctx context.Context, log logr.Logger, instance *instancev1.Instance) {
+	r.emit(ctx, log, instance, event.TypeInstanceEnded, func() error {
+		return r.Emitter.InstanceEnded(ctx, instance)
+	})
+}
+
+// emitMetadataChanged compares the metadata of the instance to the one last observed by
+// the reconciler. It emits an InstanceStateChangedEvent if the state changed semantically and
+// a player joined or left event for every player added to or removed from the players list.
+// The events are emitted before the observed metadata is recorded, so every change is emitted
+// at least once. Player left events carry the player as it was last observed.
+func (r *InstanceReconciler) emitMetadataChanged(ctx context.Context, log logr.Logger, instance *instancev1.Instance) error {
+	current, err := observeMetadata(instance.Status.Metadata)
 	if err != nil {
 		return err
 	}
+	old := instance.Status.ObservedMetadata
+	if old != nil && old.Hash == current.Hash {
+		return nil
+	}
 
-	instance.Status.ID = id.String()
-	instance.Status.State = instancev1.StateInitializing
+	if old != nil {
+		joined, left := diffPlayers(old.Players, instance.Status.Metadata.Players)
+		if !jsonEqual(old.State, current.State) {
+			r.emit(ctx, log, instance, event.TypeInstanceStateChanged, func() error {
+				return r.Emitter.InstanceStateChanged(ctx, instance, old.State, current.State)
+			})
+		}
+		for _, player := range joined {
+			player := player
+			r.emit(ctx, log.WithValues("player_id", player.ID), instance, event.TypePlayerJoined, func() error {
+				return r.Emitter.PlayerJoined(ctx, instance, player)
+			})
+		}
+		for _, player := range left {
+			player := player
+			r.emit(ctx, log.WithValues("player_id", player.ID), instance, event.TypePlayerLeft, func() error {
+				return r.Emitter.PlayerLeft(ctx, instance, player)
+			})
+		}
+	}
+
+	patch := client.MergeFrom(instance.DeepCopy())
+	instance.Status.ObservedMetadata = current
+	return r.Status().Patch(ctx, instance, patch)
+}
 
-	pod, err := r.createPod(instance)
+// observeMetadata describes the metadata by the hash of its canonical
+// JSON encoding, which ignores formatting and the order of keys
+func observeMetadata(metadata instancev1.InstanceMetadata) (*instancev1.ObservedMetadata, error) {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+	var canonical interface{}
+	if err := json.Unmarshal(data, &canonical); err != nil {
+		return nil, err
+	}
+	// maps are encoded with sorted keys
+	data, err = json.Marshal(canonical)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+
+	return &instancev1.ObservedMetadata{
+		Hash:    hex.EncodeToString(sum[:]),
+		State:   metadata.State,
+		Players: metadata.Players,
+	}, nil
+}
+
+// jsonEqual reports whether a and b hold the same JSON value, ignoring formatting and the
+// order of keys. Empty values are treated as empty objects.
+func jsonEqual(a, b json.RawMessage) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	var av, bv interface{}
+	if err := json.Unmarshal(orEmptyObject(a), &av); err != nil {
+		return false
+	}
+	if err := json.Unmarshal(orEmptyObject(b), &bv); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(av, bv)
+}
+
+func orEmptyObject(raw json.RawMessage) json.RawMessage {
+	if len(raw) == 0 {
+		return json.RawMessage("{}")
+	}
+	return raw
+}
+
+// diffPlayers returns the players of current whose IDs are missing in previous
+// and the players of previous whose IDs are missing in current
+func diffPlayers(previous, current []instancev1.InstancePlayer) (joined, left []instancev1.InstancePlayer) {
+	ids := make(map[string]bool, len(previous))
+	for _, player := range previous {
+		ids[player.ID] = true
+	}
+	for _, player := range current {
+		if !ids[player.ID] {
+			joined = append(joined, player)
+		}
+		delete(ids, player.ID)
+	}
+	for _, player := range previous {
+		if ids[player.ID] {
+			left = append(left, player)
+		}
+	}
+	return joined, left
+}
+
+// initInstance creates the pods of the new instance. The id is recorded before the pods are
+// created and pods that already exist for the id are adopted, so a retried initialization
+// never creates a second pod.
+func (r *InstanceReconciler) initInstance(ctx context.Context, instance *instancev1.Instance) error {
+	owned, err := ownedPods(ctx, r, instance)
 	if err != nil {
 		return err
 	}
 
-	if err := r.Create(ctx, pod); err != nil {
+	if len(instance.Status.ID) == 0 {
+		patch := client.MergeFrom(instance.DeepCopy())
+		instance.Status.ID = instanceID(instance, owned)
+		instance.Status.PodName = instance.Status.ID
+		if err := r.Status().Patch(ctx, instance, patch); err != nil {
+			return err
+		}
+	}
+
+	pods := sortPods(instance, owned)
+	if pods.Primary != nil && pods.Primary.DeletionTimestamp == nil {
+		r.event(instance, corev1.EventTypeNormal, "PodAdopted", "Adopted existing pod %s", pods.Primary.Name)
+	} else {
+		pod, err := r.createPod(instance, podName(instance), instance.Spec.Template)
+		if err != nil {
+			return err
+		}
+		if err := r.Create(ctx, pod); err != nil {
+			return err
+		}
+		r.event(instance, corev1.EventTypeNormal, "PodCreated", "Created pod %s", pod.Name)
+	}
+
+	if err := r.syncCompanionPods(ctx, instance, pods.Companions); err != nil {
 		return err
 	}
 
-	if err := r.Update(ctx, instance); err != nil {
+	observed, err := observeMetadata(instance.Status.Metadata)
+	if err != nil {
+		return err
+	}
+	patch := client.MergeFrom(instance.DeepCopy())
+	instance.Status.State = instancev1.StateInitializing
+	instance.Status.ObservedMetadata = observed
+	if err := r.Status().Patch(ctx, instance, patch); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// cleanupInstance deletes the instance whose pod is gone or terminated for good.
+// Terminated pods are deleted along with the instance.
 func (r *InstanceReconciler) cleanupInstance(ctx context.Context, instance instancev1.Instance) error {
+	// the pod of an ending instance was seen to terminate
+	if instance.Status.State != instancev1.StateEnding {
+		r.event(&instance, corev1.EventTypeWarning, "PodLost", "Pod %s is gone", podName(&instance))
+		podsLost.WithLabelValues(instance.Namespace).Inc()
+	}
 	if err := r.Delete(ctx, &instance); err != nil {
 		return err
 	}
+	if instance.Status.State == instancev1.StateEnding {
+		r.event(&instance, corev1.EventTypeNormal, "CleanedUp", "Deleted instance because its pod terminated")
+	} else {
+		r.event(&instance, corev1.EventTypeNormal, "CleanedUp", "Deleted instance because its pod is gone")
+	}
 	return nil
 }
 
+// finalizeInstance tears down the deleted instance. The instance is moved to the
+// Ending state first, which allows the application to move its players. The pod is
+// deleted and the finalizer is removed once all players left, the drain timeout
+// expired or the pod terminated. A non zero duration is returned if the instance
+// needs to be drained further.
+func (r *InstanceReconciler) finalizeInstance(ctx context.Context, log logr.Logger, instance *instancev1.Instance) (time.Duration, error) {
+	if instance.Status.State != instancev1.StateEnding {
+		patch := client.MergeFrom(instance.DeepCopy())
+		instance.Status.State = instancev1.StateEnding
+		if err := r.Status().Patch(ctx, instance, patch); err != nil {
+			return 0, err
+		}
+		// an instance deleted before it was initialized never started
+		if len(instance.Status.ID) > 0 {
+			r.emitEnded(ctx, log, instance)
+		}
+	}
+
+	pods, err := getPods(ctx, r, instance)
+	if err != nil {
+		return 0, err
+	}
+
+	// There is nothing to drain if the pod is already gone or terminated
+	if pod := pods.Primary; pod != nil {
+		if remaining := drainRemaining(instance, time.Now()); remaining > 0 && !podTerminated(pod) {
+			if err := r.setConditions(ctx, instance, instancev1.InstanceCondition{
+				Type:    instancev1.InstanceDraining,
+				Status:  corev1.ConditionTrue,
+				Reason:  "WaitingForPlayers",
+				Message: fmt.Sprintf("Waiting for %d players to leave", len(instance.Status.Metadata.Players)),
+			}); err != nil {
+				return 0, err
+			}
+			return remaining, nil
+		}
+		if err := r.Delete(ctx, pod); client.IgnoreNotFound(err) != nil {
+			return 0, err
+		}
+	}
+	for _, pod := range pods.Companions {
+		if err := r.Delete(ctx, pod); client.IgnoreNotFound(err) != nil {
+			return 0, err
+		}
+	}
+
+	patch := client.MergeFrom(instance.DeepCopy())
+	controllerutil.RemoveFinalizer(instance, instancev1.InstanceFinalizer)
+	if err := r.Patch(ctx, instance, patch); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// restartInstance recreates the dead pod of the instance once the restart backoff expired.
+// The instance moves to the Ending state and an ended event is emitted as soon as its pod
+// terminated or disappeared. A pod that terminated but still exists is deleted first, we get
+// called again once it is gone. A started event is emitted once the pod has been recreated.
+// Only pods that disappeared before they were seen to terminate count as lost.
+func (r *InstanceReconciler) restartInstance(ctx context.Context, log logr.Logger, instance *instancev1.Instance) (time.Duration, error) {
+	pods, err := getPods(ctx, r, instance)
+	if err != nil {
+		return 0, err
+	}
+	pod := pods.Primary
+
+	if instance.Status.State != instancev1.StateEnding {
+		if pod == nil {
+			r.event(instance, corev1.EventTypeWarning, "PodLost", "Pod %s is gone", podName(instance))
+			podsLost.WithLabelValues(instance.Namespace).Inc()
+		}
+		patch := client.MergeFrom(instance.DeepCopy())
+		instance.Status.State = instancev1.StateEnding
+		if err := r.Status().Patch(ctx, instance, patch); err != nil {
+			return 0, err
+		}
+		r.emitEnded(ctx, log, instance)
+	}
+
+	if remaining := restartRemaining(instance, time.Now()); remaining > 0 {
+		return remaining, nil
+	}
+
+	if pod != nil {
+		if pod.DeletionTimestamp == nil {
+			log.Info("deleting terminated pod", "phase", pod.Status.Phase)
+			if err := r.Delete(ctx, pod); client.IgnoreNotFound(err) != nil {
+				return 0, err
+			}
+			r.event(instance, corev1.EventTypeNormal, "PodTerminated", "Deleting pod %s which terminated with phase %s", pod.Name, pod.Status.Phase)
+		}
+		return 0, nil
+	}
+
+	newPod, err := r.createPod(instance, podName(instance), instance.Spec.Template)
+	if err != nil {
+		return 0, err
+	}
+	if err := r.Create(ctx, newPod); err != nil {
+		return 0, err
+	}
+
+	patch := client.MergeFrom(instance.DeepCopy())
+	now := metav1.Now()
+	instance.Status.RestartCount++
+	instance.Status.LastRestartTime = &now
+	instance.Status.PodName = newPod.Name
+	instance.Status.State = instancev1.StateInitializing
+	instance.Status.IP = ""
+	instance.Status.NodeName = ""
+	if err := r.Status().Patch(ctx, instance, patch); err != nil {
+		return 0, err
+	}
+	log.Info("restarted Instance successfully", "restart_count", instance.Status.RestartCount)
+	r.event(instance, corev1.EventTypeNormal, "Restarted", "Recreated pod %s, restart %d", newPod.Name, instance.Status.RestartCount)
+	r.emitStarted(ctx, log, instance)
+	return 0, nil
+}
+
+// restartRemaining returns how long the instance has to wait until its pod may be restarted.
+// The backoff doubles with every restart and is measured from the last restart.
+func restartRemaining(instance *instancev1.Instance, now time.Time) time.Duration {
+	if instance.Status.LastRestartTime == nil {
+		return 0
+	}
+
+	backoff := int64(instancev1.DefaultRestartBackoffSeconds)
+	if instance.Spec.RestartBackoffSeconds != nil {
+		backoff = *instance.Spec.RestartBackoffSeconds
+	}
+	for i := int32(1); i < instance.Status.RestartCount && backoff < instancev1.MaxRestartBackoffSeconds; i++ {
+		backoff *= 2
+	}
+	if backoff > instancev1.MaxRestartBackoffSeconds {
+		backoff = instancev1.MaxRestartBackoffSeconds
+	}
+
+	next := instance.Status.LastRestartTime.Add(time.Duration(backoff) * time.Second)
+	if remaining := next.Sub(now); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// drainRemaining returns how long the instance still has to wait for its players
+// to leave. It returns zero if there are no players left or the drain timeout expired.
+func drainRemaining(instance *instancev1.Instance, now time.Time) time.Duration {
+	if len(instance.Status.Metadata.Players) == 0 {
+		return 0
+	}
+
+	timeout := int64(instancev1.DefaultDrainTimeoutSeconds)
+	if instance.Spec.DrainTimeoutSeconds != nil {
+		timeout = *instance.Spec.DrainTimeoutSeconds
+	}
+
+	deadline := instance.DeletionTimestamp.Add(time.Duration(timeout) * time.Second)
+	if remaining := deadline.Sub(now); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+func hasFinalizer(instance *instancev1.Instance) bool {
+	for _, f := range instance.Finalizers {
+		if f == instancev1.InstanceFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *InstanceReconciler) updateInstance(ctx context.Context, instance *instancev1.Instance) error {
-	var pod corev1.Pod
-	err := r.Get(ctx, client.ObjectKey{Name: instance.Status.ID, Namespace: instance.Namespace}, &pod)
+	pods, err := getPods(ctx, r, instance)
 	if err != nil {
 		return err
 	}
+	pod := pods.Primary
+	if pod == nil {
+		return apierrors.NewNotFound(corev1.Resource("pods"), podName(instance))
+	}
+	patch := client.MergeFrom(instance.DeepCopy())
+	previousIP := instance.Status.IP
 	instance.Status.IP = pod.Status.PodIP
-	if err := r.Update(ctx, instance); err != nil {
+	instance.Status.NodeName = pod.Spec.NodeName
+	instance.Status.State = podState(pod, instance.Status.State)
+	if err := r.Status().Patch(ctx, instance, patch); err != nil {
 		return err
 	}
+	if len(instance.Status.IP) > 0 && instance.Status.IP != previousIP {
+		r.event(instance, corev1.EventTypeNormal, "IPAssigned", "Pod %s got IP %s", pod.Name, instance.Status.IP)
+	}
 	return nil
 }
 
-func (r *InstanceReconciler) createPod(instance *instancev1.Instance) (*corev1.Pod, error) {
+// createPod builds a pod of the instance called name from the template
+func (r *InstanceReconciler) createPod(instance *instancev1.Instance, name string, template corev1.PodSpec) (*corev1.Pod, error) {
 	id := instance.Status.ID
 	p := &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Labels:      make(map[string]string),
 			Annotations: make(map[string]string),
-			Name:        id,
+			Name:        name,
 			Namespace:   instance.Namespace,
 		},
-		Spec: *instance.Spec.Template.DeepCopy(),
+		Spec: *template.DeepCopy(),
 	}
 
 	for i := range p.Spec.Containers {
 		p.Spec.Containers[i].Env = append(p.Spec.Containers[i].Env, corev1.EnvVar{Name: "INSTANCE_ID", Value: id})
+		if len(r.TokenSecret) > 0 {
+			p.Spec.Containers[i].Env = append(p.Spec.Containers[i].Env, corev1.EnvVar{
+				Name:  "INSTANCE_TOKEN",
+				Value: playerapi.Token(r.TokenSecret, id),
+			})
+		}
 	}
 
 	for k, v := range instance.Annotations {
@@ -160,7 +631,7 @@ func (r *InstanceReconciler) createPod(instance *instancev1.Instance) (*corev1.P
 }
 
 func (r *InstanceReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	if err := mgr.GetFieldIndexer().IndexField(&corev1.Pod{}, ".metadata.controller", func(o runtime.Object) []string {
+	if err := mgr.GetFieldIndexer().IndexField(&corev1.Pod{}, controllerOwnerKey, func(o runtime.Object) []string {
 		pod := o.(*corev1.Pod)
 		owner := metav1.GetControllerOf(pod)
 		if owner == nil {
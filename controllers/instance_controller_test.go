@@ -1,12 +1,160 @@
 package controllers
 
-/*
 import (
+	"context"
+	"time"
+
+	instancev1 "github.com/cownetwork/instance-controller/api/v1"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 var _ = Describe("Instance controller", func() {
+	const timeout = time.Second * 10
+	const interval = time.Millisecond * 250
+	ctx := context.Background()
+
+	var instance *instancev1.Instance
+	var key client.ObjectKey
+
+	BeforeEach(func() {
+		instance = &instancev1.Instance{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "controller-",
+				Namespace:    "default",
+				Labels:       map[string]string{"game": "lobby"},
+			},
+			Spec: instancev1.InstanceSpec{
+				RestartPolicy: instancev1.RestartPolicyNever,
+				Template: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "game", Image: "game:latest"}},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, instance)).To(Succeed())
+		key = client.ObjectKey{Name: instance.Name, Namespace: instance.Namespace}
+	})
+
+	// getPod waits for the pod of the instance and returns it together with the initialized instance
+	getPod := func() (*instancev1.Instance, *corev1.Pod) {
+		created := &instancev1.Instance{}
+		Eventually(func() instancev1.InstanceState {
+			_ = k8sClient.Get(ctx, key, created)
+			return created.Status.State
+		}, timeout, interval).ShouldNot(BeEmpty())
+
+		pod := &corev1.Pod{}
+		Eventually(func() error {
+			return k8sClient.Get(ctx, client.ObjectKey{Name: created.Status.ID, Namespace: created.Namespace}, pod)
+		}, timeout, interval).Should(Succeed())
+		return created, pod
+	}
+
+	It("creates a pod named after the instance id", func() {
+		created, pod := getPod()
+		Expect(created.Status.ID).To(Equal(string(created.UID)))
+		Expect(created.Status.PodName).To(Equal(pod.Name))
+		Expect(created.Status.State).To(Equal(instancev1.StateInitializing))
+		Expect(created.Finalizers).To(ContainElement(instancev1.InstanceFinalizer))
+
+		ref := metav1.GetControllerOf(pod)
+		Expect(ref).NotTo(BeNil())
+		Expect(ref.Kind).To(Equal("Instance"))
+		Expect(ref.UID).To(Equal(created.UID))
+		Expect(pod.Labels).To(HaveKeyWithValue("game", "lobby"))
+		Expect(pod.Spec.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: "INSTANCE_ID", Value: created.Status.ID}))
+	})
+
+	It("propagates the pod IP to the instance status", func() {
+		_, pod := getPod()
+
+		By("assigning an IP to the pod")
+		pod.Status.Phase = corev1.PodPending
+		pod.Status.PodIP = "10.0.0.2"
+		Expect(k8sClient.Status().Update(ctx, pod)).To(Succeed())
+
+		updated := &instancev1.Instance{}
+		Eventually(func() string {
+			_ = k8sClient.Get(ctx, key, updated)
+			return updated.Status.IP
+		}, timeout, interval).Should(Equal("10.0.0.2"))
+		Expect(updated.Status.State).To(Equal(instancev1.StateInitializing))
+
+		By("making the pod ready")
+		pod.Status.Phase = corev1.PodRunning
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		pod.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: "game", Image: "game:latest", Ready: true}}
+		Expect(k8sClient.Status().Update(ctx, pod)).To(Succeed())
+
+		Eventually(func() instancev1.InstanceState {
+			_ = k8sClient.Get(ctx, key, updated)
+			return updated.Status.State
+		}, timeout, interval).Should(Equal(instancev1.StateRunning))
+		Expect(updated.Status.IP).To(Equal("10.0.0.2"))
+	})
+
+	It("removes the instance once its pod is gone", func() {
+		_, pod := getPod()
+
+		By("deleting the pod")
+		Expect(k8sClient.Delete(ctx, pod)).To(Succeed())
+
+		Eventually(func() bool {
+			return apierrors.IsNotFound(k8sClient.Get(ctx, key, &instancev1.Instance{}))
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("deletes the pod when the instance is deleted", func() {
+		created, pod := getPod()
+
+		By("deleting the instance")
+		Expect(k8sClient.Delete(ctx, created)).To(Succeed())
+
+		Eventually(func() bool {
+			return apierrors.IsNotFound(k8sClient.Get(ctx, key, &instancev1.Instance{}))
+		}, timeout, interval).Should(BeTrue())
+		Expect(apierrors.IsNotFound(k8sClient.Get(ctx, client.ObjectKey{Name: pod.Name, Namespace: pod.Namespace}, &corev1.Pod{}))).To(BeTrue())
+	})
+
+	It("creates and owns the companion pods", func() {
+		withCompanion := instance.DeepCopy()
+		withCompanion.ObjectMeta = metav1.ObjectMeta{GenerateName: "companion-", Namespace: "default"}
+		withCompanion.Spec.Pods = []instancev1.InstancePodTemplate{{Name: "voice", Template: instance.Spec.Template}}
+		Expect(k8sClient.Create(ctx, withCompanion)).To(Succeed())
+		key = client.ObjectKey{Name: withCompanion.Name, Namespace: withCompanion.Namespace}
+		created, pod := getPod()
+
+		companion := &corev1.Pod{}
+		Eventually(func() error {
+			return k8sClient.Get(ctx, client.ObjectKey{Name: created.Status.ID + "-voice", Namespace: created.Namespace}, companion)
+		}, timeout, interval).Should(Succeed())
+		Expect(metav1.IsControlledBy(companion, created)).To(BeTrue())
+
+		By("reporting the companion pod in the status")
+		companion.Status.Phase = corev1.PodRunning
+		companion.Status.PodIP = "10.0.0.3"
+		Expect(k8sClient.Status().Update(ctx, companion)).To(Succeed())
+
+		updated := &instancev1.Instance{}
+		Eventually(func() []instancev1.InstancePodStatus {
+			_ = k8sClient.Get(ctx, key, updated)
+			return updated.Status.Pods
+		}, timeout, interval).Should(ContainElement(instancev1.InstancePodStatus{
+			Name:    "voice",
+			PodName: companion.Name,
+			Phase:   corev1.PodRunning,
+			IP:      "10.0.0.3",
+		}))
 
+		By("deleting the primary pod")
+		Expect(k8sClient.Delete(ctx, pod)).To(Succeed())
 
-})*/
+		Eventually(func() bool {
+			return apierrors.IsNotFound(k8sClient.Get(ctx, key, &instancev1.Instance{}))
+		}, timeout, interval).Should(BeTrue())
+	})
+})
@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	instancev1 "github.com/cownetwork/instance-controller/api/v1"
+)
+
+// instancePods are the pods owned by an Instance
+type instancePods struct {
+	// Primary is the pod created from spec.template or nil if it does not exist
+	Primary *corev1.Pod
+
+	// Companions are the existing pods created from spec.pods by their name
+	Companions map[string]*corev1.Pod
+
+	// Duplicates are the other pods owned by the Instance
+	Duplicates []corev1.Pod
+}
+
+// podName returns the name of the pod of the instance. Instances
+// that did not record the name of their pod use their id.
+func podName(instance *instancev1.Instance) string {
+	if len(instance.Status.PodName) > 0 {
+		return instance.Status.PodName
+	}
+	return instance.Status.ID
+}
+
+// companionPodName returns the name of the companion pod called name
+func companionPodName(instance *instancev1.Instance, name string) string {
+	return instance.Status.ID + "-" + name
+}
+
+// ownedPods lists the pods controlled by the instance using the .metadata.controller index, oldest first.
+// Pods of a previous instance with the same name are not included.
+func ownedPods(ctx context.Context, c client.Reader, instance *instancev1.Instance) ([]corev1.Pod, error) {
+	var list corev1.PodList
+	if err := c.List(ctx, &list, client.InNamespace(instance.Namespace), client.MatchingFields{controllerOwnerKey: instance.Name}); err != nil {
+		return nil, err
+	}
+
+	pods := make([]corev1.Pod, 0, len(list.Items))
+	for _, pod := range list.Items {
+		if metav1.IsControlledBy(&pod, instance) {
+			pods = append(pods, pod)
+		}
+	}
+	sort.SliceStable(pods, func(i, j int) bool {
+		return pods[i].CreationTimestamp.Before(&pods[j].CreationTimestamp)
+	})
+	return pods, nil
+}
+
+// getPods returns the pods owned by the instance
+func getPods(ctx context.Context, c client.Reader, instance *instancev1.Instance) (instancePods, error) {
+	pods, err := ownedPods(ctx, c, instance)
+	if err != nil {
+		return instancePods{}, err
+	}
+	return sortPods(instance, pods), nil
+}
+
+// sortPods sorts the pods owned by the instance into its primary pod, companion pods and duplicates
+func sortPods(instance *instancev1.Instance, pods []corev1.Pod) instancePods {
+	result := instancePods{Companions: make(map[string]*corev1.Pod)}
+	companions := make(map[string]string, len(instance.Spec.Pods))
+	if len(instance.Status.ID) > 0 {
+		for _, template := range instance.Spec.Pods {
+			companions[companionPodName(instance, template.Name)] = template.Name
+		}
+	}
+
+	name := podName(instance)
+	for i := range pods {
+		pod := &pods[i]
+		if result.Primary == nil && len(name) > 0 && pod.Name == name {
+			result.Primary = pod
+			continue
+		}
+		if companion, ok := companions[pod.Name]; ok && result.Companions[companion] == nil {
+			result.Companions[companion] = pod
+			continue
+		}
+		result.Duplicates = append(result.Duplicates, *pod)
+	}
+	return result
+}
+
+// instanceID returns the id of a new instance. The pod of an instance is named after its id,
+// so if a pod owned by the instance already exists it is adopted and its name is used.
+// Otherwise the id is derived from the UID of the instance, which makes sure that
+// retrying a failed initialization never creates a second pod.
+func instanceID(instance *instancev1.Instance, pods []corev1.Pod) string {
+	for _, pod := range pods {
+		if pod.DeletionTimestamp == nil {
+			return pod.Name
+		}
+	}
+	return string(instance.UID)
+}
+
+// podInstanceID returns the id of the instance handed to the pod or an empty string
+func podInstanceID(pod *corev1.Pod) string {
+	for _, c := range pod.Spec.Containers {
+		for _, env := range c.Env {
+			if env.Name == "INSTANCE_ID" {
+				return env.Value
+			}
+		}
+	}
+	return ""
+}
+
+// recordedID returns the id handed to the pod of the instance. Instances that did
+// not record the name of their pod fall back to the oldest pod carrying an id.
+func recordedID(instance *instancev1.Instance, pods []corev1.Pod) string {
+	if len(instance.Status.PodName) > 0 {
+		for i := range pods {
+			if pods[i].Name == instance.Status.PodName {
+				return podInstanceID(&pods[i])
+			}
+		}
+		return ""
+	}
+	for i := range pods {
+		if id := podInstanceID(&pods[i]); len(id) > 0 {
+			return id
+		}
+	}
+	return ""
+}
+
+// syncPods deletes the duplicate pods owned by the instance and makes sure its companion pods
+// exist as long as the instance is not ending. Duplicates are left overs of initializations
+// that failed before the id could be recorded.
+// The id is assigned once, if it has been changed it is restored from the pods.
+func (r *InstanceReconciler) syncPods(ctx context.Context, instance *instancev1.Instance) error {
+	owned, err := ownedPods(ctx, r, instance)
+	if err != nil {
+		return err
+	}
+
+	if id := recordedID(instance, owned); len(id) > 0 && id != instance.Status.ID {
+		r.event(instance, corev1.EventTypeWarning, "IDRestored", "Restored id %s, the id must not be changed", id)
+		patch := client.MergeFrom(instance.DeepCopy())
+		instance.Status.ID = id
+		if err := r.Status().Patch(ctx, instance, patch); err != nil {
+			return err
+		}
+	}
+	pods := sortPods(instance, owned)
+
+	for i := range pods.Duplicates {
+		pod := &pods.Duplicates[i]
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		if err := r.Delete(ctx, pod); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		r.event(instance, corev1.EventTypeWarning, "StrayPodDeleted", "Deleted duplicate pod %s, the pod of the instance is %s", pod.Name, podName(instance))
+	}
+
+	if instance.DeletionTimestamp != nil || instance.Status.State == instancev1.StateEnding {
+		return nil
+	}
+	return r.syncCompanionPods(ctx, instance, pods.Companions)
+}
+
+// syncCompanionPods creates the companion pods of the instance that do not exist.
+// Companion pods that terminated are deleted, they are recreated once they are gone.
+func (r *InstanceReconciler) syncCompanionPods(ctx context.Context, instance *instancev1.Instance, companions map[string]*corev1.Pod) error {
+	for _, template := range instance.Spec.Pods {
+		pod := companions[template.Name]
+		switch {
+		case pod == nil:
+			pod, err := r.createPod(instance, companionPodName(instance, template.Name), template.Template)
+			if err != nil {
+				return err
+			}
+			if err := r.Create(ctx, pod); err != nil {
+				return err
+			}
+			r.event(instance, corev1.EventTypeNormal, "PodCreated", "Created companion pod %s", pod.Name)
+		case podTerminated(pod) && pod.DeletionTimestamp == nil:
+			if err := r.Delete(ctx, pod); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+			r.event(instance, corev1.EventTypeNormal, "PodTerminated", "Deleting companion pod %s which terminated with phase %s", pod.Name, pod.Status.Phase)
+		}
+	}
+	return nil
+}
+
+// companionPodStatuses describes the companion pods of the instance
+func companionPodStatuses(instance *instancev1.Instance, companions map[string]*corev1.Pod) []instancev1.InstancePodStatus {
+	if len(instance.Spec.Pods) == 0 {
+		return nil
+	}
+
+	statuses := make([]instancev1.InstancePodStatus, 0, len(instance.Spec.Pods))
+	for _, template := range instance.Spec.Pods {
+		status := instancev1.InstancePodStatus{
+			Name:    template.Name,
+			PodName: companionPodName(instance, template.Name),
+		}
+		if pod := companions[template.Name]; pod != nil {
+			status.Phase = pod.Status.Phase
+			status.Ready = podReady(pod)
+			status.IP = pod.Status.PodIP
+			status.NodeName = pod.Spec.NodeName
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
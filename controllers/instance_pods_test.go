@@ -0,0 +1,432 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	instanceapiv1 "github.com/cownetwork/mooapis-go/cow/instance/v1"
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	instancev1 "github.com/cownetwork/instance-controller/api/v1"
+	"github.com/cownetwork/instance-controller/event"
+)
+
+func newPodTestReconciler(objs ...runtime.Object) (*InstanceReconciler, *instancev1.Instance) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = instancev1.AddToScheme(scheme)
+
+	instance := &instancev1.Instance{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", UID: types.UID("6f1b5a2e-0c55-4d8e-9a0e-1d2f3c4b5a69")},
+		Spec: instancev1.InstanceSpec{
+			Template: corev1.PodSpec{Containers: []corev1.Container{{Name: "game", Image: "game:latest"}}},
+		},
+	}
+	r := &InstanceReconciler{
+		Client: fake.NewFakeClientWithScheme(scheme, append(objs, instance)...),
+		Log:    ctrl.Log.WithName("test"),
+		Scheme: scheme,
+	}
+	return r, instance
+}
+
+func ownedPod(t *testing.T, r *InstanceReconciler, owner *instancev1.Instance, name string) *corev1.Pod {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: owner.Namespace}}
+	if err := ctrl.SetControllerReference(owner, pod, r.Scheme); err != nil {
+		t.Fatal(err)
+	}
+	return pod
+}
+
+func podNames(t *testing.T, r *InstanceReconciler) []string {
+	var list corev1.PodList
+	if err := r.List(context.Background(), &list, client.InNamespace("default")); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, pod := range list.Items {
+		names = append(names, pod.Name)
+	}
+	return names
+}
+
+func TestInitInstanceIsIdempotent(t *testing.T) {
+	ctx := context.Background()
+	r, instance := newPodTestReconciler()
+	instance.Spec.Pods = []instancev1.InstancePodTemplate{{Name: "voice", Template: instance.Spec.Template}}
+
+	if err := r.initInstance(ctx, instance); err != nil {
+		t.Fatalf("initInstance() error = %v", err)
+	}
+	if instance.Status.ID != string(instance.UID) {
+		t.Errorf("Status.ID = %q, want the UID %q", instance.Status.ID, instance.UID)
+	}
+	if instance.Status.State != instancev1.StateInitializing {
+		t.Errorf("Status.State = %q, want %q", instance.Status.State, instancev1.StateInitializing)
+	}
+
+	// the pods were created, but recording the state failed
+	instance.Status.State = ""
+	if err := r.initInstance(ctx, instance); err != nil {
+		t.Fatalf("retried initInstance() error = %v", err)
+	}
+	id := instance.Status.ID
+	if names := podNames(t, r); len(names) != 2 || names[0] != id || names[1] != id+"-voice" {
+		t.Errorf("pods = %v, want only [%s %s-voice]", names, id, id)
+	}
+}
+
+func TestDecideAfterInitWaitsForPod(t *testing.T) {
+	ctx := context.Background()
+	r, instance := newPodTestReconciler()
+	if err := r.initInstance(ctx, instance); err != nil {
+		t.Fatalf("initInstance() error = %v", err)
+	}
+
+	// the cache has not seen the created pod yet
+	cache := fake.NewFakeClientWithScheme(r.Scheme, instance.DeepCopy())
+	decider := NewDefaultDecider(cache, r.Client)
+	action, err := decider.Decide(ctx, *instance, ctrl.Request{})
+	if err != nil {
+		t.Fatalf("Decide() error = %v", err)
+	}
+	if action != ActionWait {
+		t.Errorf("Decide() = %v, want %v until the pod is observed", action, ActionWait)
+	}
+
+	// the pod is really gone
+	if err := r.Delete(ctx, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: podName(instance), Namespace: instance.Namespace}}); err != nil {
+		t.Fatal(err)
+	}
+	action, err = decider.Decide(ctx, *instance, ctrl.Request{})
+	if err != nil {
+		t.Fatalf("Decide() error = %v", err)
+	}
+	if action != ActionCleanup {
+		t.Errorf("Decide() = %v, want %v once the pod is gone", action, ActionCleanup)
+	}
+}
+
+func TestInitInstanceAdoptsOwnedPod(t *testing.T) {
+	ctx := context.Background()
+	r, instance := newPodTestReconciler()
+	orphan := ownedPod(t, r, instance, "0b4a9f8e-3c1d-4e2f-8a7b-6c5d4e3f2a1b")
+	if err := r.Create(ctx, orphan); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.initInstance(ctx, instance); err != nil {
+		t.Fatalf("initInstance() error = %v", err)
+	}
+	if instance.Status.ID != orphan.Name {
+		t.Errorf("Status.ID = %q, want the name of the adopted pod %q", instance.Status.ID, orphan.Name)
+	}
+	if names := podNames(t, r); len(names) != 1 || names[0] != orphan.Name {
+		t.Errorf("pods = %v, want only %q", names, orphan.Name)
+	}
+}
+
+func TestSyncPodsDeletesDuplicates(t *testing.T) {
+	ctx := context.Background()
+	r, instance := newPodTestReconciler()
+	instance.Status.ID = "current"
+
+	other := instance.DeepCopy()
+	other.UID = types.UID("previous-instance")
+	for _, pod := range []*corev1.Pod{
+		ownedPod(t, r, instance, "current"),
+		ownedPod(t, r, instance, "stray"),
+		ownedPod(t, r, other, "foreign"),
+	} {
+		if err := r.Create(ctx, pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := r.syncPods(ctx, instance); err != nil {
+		t.Fatalf("syncPods() error = %v", err)
+	}
+	names := podNames(t, r)
+	if len(names) != 2 || names[0] != "current" || names[1] != "foreign" {
+		t.Errorf("pods = %v, want [current foreign]", names)
+	}
+}
+
+func TestUpdateConditionsReportsDuplicatePods(t *testing.T) {
+	ctx := context.Background()
+	r, instance := newPodTestReconciler()
+	instance.Status.ID = string(instance.UID)
+	instance.Status.PodName = "game-0"
+	instance.Status.State = instancev1.StateInitializing
+
+	for _, name := range []string{"game-0", "game-1"} {
+		if err := r.Create(ctx, ownedPod(t, r, instance, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pods, err := getPods(ctx, r, instance)
+	if err != nil {
+		t.Fatalf("getPods() error = %v", err)
+	}
+	if pods.Primary == nil || pods.Primary.Name != "game-0" {
+		t.Errorf("getPods() primary = %v, want game-0", pods.Primary)
+	}
+	if len(pods.Duplicates) != 1 || pods.Duplicates[0].Name != "game-1" {
+		t.Errorf("getPods() duplicates = %v, want [game-1]", pods.Duplicates)
+	}
+
+	if err := r.updateConditions(ctx, instance); err != nil {
+		t.Fatalf("updateConditions() error = %v", err)
+	}
+	if !instance.Status.IsConditionTrue(instancev1.InstanceDuplicatePods) {
+		t.Errorf("condition %s = %v, want True", instancev1.InstanceDuplicatePods, instance.Status.Condition(instancev1.InstanceDuplicatePods))
+	}
+}
+
+func TestSyncPodsRecreatesCompanionPods(t *testing.T) {
+	ctx := context.Background()
+	r, instance := newPodTestReconciler()
+	instance.Status.ID = "id"
+	instance.Status.State = instancev1.StateRunning
+	instance.Spec.Pods = []instancev1.InstancePodTemplate{
+		{Name: "replay", Template: instance.Spec.Template},
+		{Name: "voice", Template: instance.Spec.Template},
+	}
+
+	primary := ownedPod(t, r, instance, "id")
+	primary.Status.Phase = corev1.PodRunning
+	primary.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	primary.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: "game", Ready: true}}
+	voice := ownedPod(t, r, instance, "id-voice")
+	voice.Status.Phase = corev1.PodFailed
+	for _, pod := range []*corev1.Pod{primary, voice} {
+		if err := r.Create(ctx, pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := r.syncPods(ctx, instance); err != nil {
+		t.Fatalf("syncPods() error = %v", err)
+	}
+	names := podNames(t, r)
+	if len(names) != 2 || names[0] != "id" || names[1] != "id-replay" {
+		t.Errorf("pods = %v, want the missing replay pod created and the failed voice pod deleted", names)
+	}
+
+	if err := r.syncPods(ctx, instance); err != nil {
+		t.Fatalf("syncPods() error = %v", err)
+	}
+	if names := podNames(t, r); len(names) != 3 || names[2] != "id-voice" {
+		t.Errorf("pods = %v, want the voice pod recreated", names)
+	}
+
+	if err := r.updateConditions(ctx, instance); err != nil {
+		t.Fatalf("updateConditions() error = %v", err)
+	}
+	if len(instance.Status.Pods) != 2 || instance.Status.Pods[0].PodName != "id-replay" || instance.Status.Pods[1].PodName != "id-voice" {
+		t.Errorf("Status.Pods = %+v, want the status of the replay and voice pods", instance.Status.Pods)
+	}
+	if ready := instance.Status.Condition(instancev1.InstanceReady); ready == nil || ready.Reason != "CompanionPodsNotReady" {
+		t.Errorf("condition %s = %v, want False because of the companion pods", instancev1.InstanceReady, ready)
+	}
+}
+
+func TestRestartInstanceEmitsEndedAndStarted(t *testing.T) {
+	ctx := context.Background()
+	r, instance := newPodTestReconciler()
+	sink := &event.MemorySink{}
+	r.Emitter = event.NewEmitter(sink, "test")
+	instance.Spec.RestartPolicy = instancev1.RestartPolicyAlways
+	instance.Status.ID = string(instance.UID)
+	instance.Status.State = instancev1.StateRunning
+
+	pod := ownedPod(t, r, instance, instance.Status.ID)
+	pod.Status.Phase = corev1.PodFailed
+	if err := r.Create(ctx, pod); err != nil {
+		t.Fatal(err)
+	}
+
+	lost := testutil.ToFloat64(podsLost.WithLabelValues(instance.Namespace))
+	log := ctrl.Log.WithName("test")
+	if _, err := r.restartInstance(ctx, log, instance); err != nil {
+		t.Fatalf("restartInstance() error = %v", err)
+	}
+	if instance.Status.State != instancev1.StateEnding {
+		t.Errorf("Status.State = %q, want %q while the terminated pod is deleted", instance.Status.State, instancev1.StateEnding)
+	}
+	if names := podNames(t, r); len(names) != 0 {
+		t.Errorf("pods = %v, want the terminated pod to be deleted", names)
+	}
+
+	if _, err := r.restartInstance(ctx, log, instance); err != nil {
+		t.Fatalf("second restartInstance() error = %v", err)
+	}
+	if instance.Status.State != instancev1.StateInitializing || instance.Status.RestartCount != 1 {
+		t.Errorf("Status = %q after %d restarts, want %q after 1", instance.Status.State, instance.Status.RestartCount, instancev1.StateInitializing)
+	}
+	if names := podNames(t, r); len(names) != 1 || names[0] != instance.Status.ID {
+		t.Errorf("pods = %v, want [%s]", names, instance.Status.ID)
+	}
+	if got := testutil.ToFloat64(podsLost.WithLabelValues(instance.Namespace)); got != lost {
+		t.Errorf("counted %v lost pods, want none for a pod deleted by the controller", got-lost)
+	}
+
+	var emitted []string
+	for _, e := range sink.Events() {
+		emitted = append(emitted, e.Type())
+	}
+	if len(emitted) != 2 || emitted[0] != event.TypeInstanceEnded || emitted[1] != event.TypeInstanceStarted {
+		t.Errorf("events = %v, want [%s %s]", emitted, event.TypeInstanceEnded, event.TypeInstanceStarted)
+	}
+}
+
+func TestSyncPodsRestoresID(t *testing.T) {
+	ctx := context.Background()
+	r, instance := newPodTestReconciler()
+	instance.Status.ID = string(instance.UID)
+	instance.Status.PodName = instance.Status.ID
+	instance.Status.State = instancev1.StateRunning
+
+	pod, err := r.createPod(instance, instance.Status.PodName, instance.Spec.Template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Create(ctx, pod); err != nil {
+		t.Fatal(err)
+	}
+
+	instance.Status.ID = "changed"
+	if err := r.syncPods(ctx, instance); err != nil {
+		t.Fatalf("syncPods() error = %v", err)
+	}
+	if instance.Status.ID != pod.Name {
+		t.Errorf("Status.ID = %q, want it to be restored to %q", instance.Status.ID, pod.Name)
+	}
+	if names := podNames(t, r); len(names) != 1 || names[0] != pod.Name {
+		t.Errorf("pods = %v, want only %q", names, pod.Name)
+	}
+}
+
+func TestEmitMetadataChanged(t *testing.T) {
+	ctx := context.Background()
+	r, instance := newPodTestReconciler()
+	sink := &event.MemorySink{}
+	r.Emitter = event.NewEmitter(sink, "test")
+	instance.Status.ID = string(instance.UID)
+	instance.Status.Metadata = instancev1.InstanceMetadata{
+		State:   json.RawMessage(`{"map":"lobby","round":1}`),
+		Players: []instancev1.InstancePlayer{{ID: "alice", Metadata: json.RawMessage(`{"team":"red"}`)}, {ID: "bob"}},
+	}
+	observed, err := observeMetadata(instance.Status.Metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance.Status.ObservedMetadata = observed
+
+	// reformatting the state is no change
+	instance.Status.Metadata.State = json.RawMessage(`{"round": 1, "map": "lobby"}`)
+	if err := r.emitMetadataChanged(ctx, r.Log, instance); err != nil {
+		t.Fatalf("emitMetadataChanged() error = %v", err)
+	}
+	if events := sink.Events(); len(events) != 0 {
+		t.Fatalf("got %d events for reformatted metadata, want none", len(events))
+	}
+
+	instance.Status.Metadata.State = json.RawMessage(`{"map":"arena","round":1}`)
+	instance.Status.Metadata.Players = []instancev1.InstancePlayer{{ID: "bob"}, {ID: "carol"}}
+	if err := r.emitMetadataChanged(ctx, r.Log, instance); err != nil {
+		t.Fatalf("emitMetadataChanged() error = %v", err)
+	}
+	var emitted []string
+	for _, e := range sink.Events() {
+		emitted = append(emitted, e.Type()+" "+e.Subject())
+	}
+	want := []string{
+		event.TypeInstanceStateChanged + " ",
+		event.TypePlayerJoined + " " + instance.Status.ID,
+		event.TypePlayerLeft + " " + instance.Status.ID,
+	}
+	if !reflect.DeepEqual(emitted, want) {
+		t.Errorf("events = %v, want %v", emitted, want)
+	}
+	var left instanceapiv1.Player
+	if err := proto.Unmarshal(sink.Events()[2].Data(), &left); err != nil {
+		t.Fatal(err)
+	}
+	if left.Id != "alice" || left.Metadata.GetFields()["team"].GetStringValue() != "red" {
+		t.Errorf("left player = %v, want alice with the metadata last observed", &left)
+	}
+	if players := instance.Status.ObservedMetadata.Players; len(players) != 2 || players[0].ID != "bob" || players[1].ID != "carol" {
+		t.Errorf("observed players = %v, want [bob carol]", players)
+	}
+
+	sink.Reset()
+	if err := r.emitMetadataChanged(ctx, r.Log, instance); err != nil {
+		t.Fatalf("emitMetadataChanged() error = %v", err)
+	}
+	if events := sink.Events(); len(events) != 0 {
+		t.Errorf("got %d events for observed metadata, want none", len(events))
+	}
+}
+
+func TestFinalizeInstanceWithTerminatedPod(t *testing.T) {
+	ctx := context.Background()
+	r, instance := newPodTestReconciler()
+	sink := &event.MemorySink{}
+	r.Emitter = event.NewEmitter(sink, "test")
+	now := metav1.Now()
+	instance.DeletionTimestamp = &now
+	instance.Finalizers = []string{instancev1.InstanceFinalizer}
+	instance.Status.ID = string(instance.UID)
+	instance.Status.State = instancev1.StateRunning
+	instance.Status.Metadata.Players = []instancev1.InstancePlayer{{ID: "alice"}}
+
+	pod := ownedPod(t, r, instance, instance.Status.ID)
+	pod.Status.Phase = corev1.PodSucceeded
+	if err := r.Create(ctx, pod); err != nil {
+		t.Fatal(err)
+	}
+
+	requeue, err := r.finalizeInstance(ctx, r.Log, instance)
+	if err != nil {
+		t.Fatalf("finalizeInstance() error = %v", err)
+	}
+	if requeue != 0 || hasFinalizer(instance) {
+		t.Errorf("finalizeInstance() = %v, finalizer removed = %v, want the instance to be finalized at once", requeue, !hasFinalizer(instance))
+	}
+	if names := podNames(t, r); len(names) != 0 {
+		t.Errorf("pods = %v, want the terminated pod to be deleted", names)
+	}
+	if events := sink.Events(); len(events) != 1 || events[0].Type() != event.TypeInstanceEnded {
+		t.Errorf("got %d events, want the ended event", len(events))
+	}
+}
+
+func TestFinalizeUninitializedInstance(t *testing.T) {
+	ctx := context.Background()
+	r, instance := newPodTestReconciler()
+	sink := &event.MemorySink{}
+	r.Emitter = event.NewEmitter(sink, "test")
+	now := metav1.Now()
+	instance.DeletionTimestamp = &now
+	instance.Finalizers = []string{instancev1.InstanceFinalizer}
+
+	if _, err := r.finalizeInstance(ctx, r.Log, instance); err != nil {
+		t.Fatalf("finalizeInstance() error = %v", err)
+	}
+	if events := sink.Events(); len(events) != 0 {
+		t.Errorf("got %d events, want none for an instance that never started", len(events))
+	}
+}
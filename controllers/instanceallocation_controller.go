@@ -0,0 +1,271 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	instancev1 "github.com/cownetwork/instance-controller/api/v1"
+)
+
+// pendingAllocationRetry is the delay after which a pending allocation looks for instances again
+const pendingAllocationRetry = 5 * time.Second
+
+// allocationUIDKey indexes Instances by the UID of the allocation that claimed them
+const allocationUIDKey = ".status.allocation.uid"
+
+// InstanceAllocationReconciler reconciles a InstanceAllocation object
+type InstanceAllocationReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+
+	// APIReader reads Instances bypassing the cache, which may not contain a claim
+	// we just wrote yet. The Client is used if it is nil.
+	APIReader client.Reader
+}
+
+// +kubebuilder:rbac:groups=instance.cow.network,resources=instanceallocations,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=instance.cow.network,resources=instanceallocations/status,verbs=get;update;patch
+func (r *InstanceAllocationReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("allocation_name", req.Name, "namespace", req.Namespace)
+
+	var allocation instancev1.InstanceAllocation
+	if err := r.Get(ctx, req.NamespacedName, &allocation); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if allocation.DeletionTimestamp != nil {
+		if !hasAllocationFinalizer(&allocation) {
+			return ctrl.Result{}, nil
+		}
+		if err := r.release(ctx, &allocation); err != nil {
+			return ctrl.Result{}, err
+		}
+		patch := client.MergeFrom(allocation.DeepCopy())
+		controllerutil.RemoveFinalizer(&allocation, instancev1.AllocationFinalizer)
+		if err := r.Patch(ctx, &allocation, patch); err != nil {
+			return ctrl.Result{}, err
+		}
+		log.Info("released allocation")
+		return ctrl.Result{}, nil
+	}
+
+	// The claim has to be released once the allocation is deleted
+	if !hasAllocationFinalizer(&allocation) {
+		patch := client.MergeFrom(allocation.DeepCopy())
+		controllerutil.AddFinalizer(&allocation, instancev1.AllocationFinalizer)
+		if err := r.Patch(ctx, &allocation, patch); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if allocation.Status.State == instancev1.AllocationStateAllocated {
+		return ctrl.Result{}, nil
+	}
+
+	// We may already have claimed an instance, but failed to record it
+	instance, err := r.claimedInstance(ctx, &allocation)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if instance == nil {
+		instances, err := r.candidates(ctx, &allocation)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+
+		instance, err = r.allocate(ctx, &allocation, instances)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	patch := client.MergeFrom(allocation.DeepCopy())
+	if instance == nil {
+		allocation.Status.State = instancev1.AllocationStatePending
+		if err := r.Status().Patch(ctx, &allocation, patch); err != nil {
+			return ctrl.Result{}, err
+		}
+		log.Info("no instance available")
+		return ctrl.Result{RequeueAfter: pendingAllocationRetry}, nil
+	}
+
+	allocation.Status.State = instancev1.AllocationStateAllocated
+	allocation.Status.InstanceName = instance.Name
+	allocation.Status.InstanceID = instance.Status.ID
+	allocation.Status.IP = instance.Status.IP
+	if err := r.Status().Patch(ctx, &allocation, patch); err != nil {
+		return ctrl.Result{}, err
+	}
+	log.Info("allocated instance", "instance_name", instance.Name, "instance_id", instance.Status.ID)
+
+	return ctrl.Result{}, nil
+}
+
+// claimedInstance returns the instance claimed by the allocation or nil if there is none
+func (r *InstanceAllocationReconciler) claimedInstance(ctx context.Context, allocation *instancev1.InstanceAllocation) (*instancev1.Instance, error) {
+	var list instancev1.InstanceList
+	if err := r.List(ctx, &list, client.InNamespace(allocation.Namespace), client.MatchingFields{allocationUIDKey: string(allocation.UID)}); err != nil {
+		return nil, err
+	}
+	for i := range list.Items {
+		if claimedBy(&list.Items[i], allocation) {
+			return &list.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// candidates lists the instances matching the selector of the allocation, oldest first
+func (r *InstanceAllocationReconciler) candidates(ctx context.Context, allocation *instancev1.InstanceAllocation) ([]instancev1.Instance, error) {
+	selector, err := metav1.LabelSelectorAsSelector(&allocation.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	var list instancev1.InstanceList
+	if err := r.List(ctx, &list, client.InNamespace(allocation.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+
+	instances := list.Items
+	sort.SliceStable(instances, func(i, j int) bool {
+		return instances[i].CreationTimestamp.Before(&instances[j].CreationTimestamp)
+	})
+	return instances, nil
+}
+
+// allocate claims the first available instance for the allocation.
+// Nil is returned if no instance is available.
+func (r *InstanceAllocationReconciler) allocate(ctx context.Context, allocation *instancev1.InstanceAllocation, instances []instancev1.Instance) (*instancev1.Instance, error) {
+	for i := range instances {
+		claimed, err := r.claim(ctx, allocation, &instances[i])
+		if apierrors.IsConflict(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if claimed != nil {
+			return claimed, nil
+		}
+	}
+	return nil, nil
+}
+
+// claim claims the instance for the allocation if it is available. The claim is written using the
+// resource version of the instance, so if two allocations race for the same instance only one of them
+// succeeds. A conflicting instance is read again bypassing the cache, because the conflict may be our
+// own claim the cache has not seen yet or a change that left the instance available, in which case
+// claiming it is retried. Nil is returned if the instance is not available.
+func (r *InstanceAllocationReconciler) claim(ctx context.Context, allocation *instancev1.InstanceAllocation, instance *instancev1.Instance) (*instancev1.Instance, error) {
+	var claimed *instancev1.Instance
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if !allocatable(instance) {
+			return nil
+		}
+
+		instance.Status.Allocation = &instancev1.AllocationRef{
+			Name:     allocation.Name,
+			UID:      allocation.UID,
+			Metadata: allocation.Spec.Metadata,
+			Time:     metav1.Now(),
+		}
+		err := r.Status().Update(ctx, instance)
+		if err == nil {
+			claimed = instance
+			return nil
+		}
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if !apierrors.IsConflict(err) {
+			return err
+		}
+
+		var current instancev1.Instance
+		if err := r.reader().Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: instance.Name}, &current); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if claimedBy(&current, allocation) {
+			claimed = &current
+			return nil
+		}
+		instance = &current
+		return err
+	})
+	return claimed, err
+}
+
+// release removes the claim of the allocation from the instances it claimed.
+// The instances are read bypassing the cache to not miss a claim written just before.
+func (r *InstanceAllocationReconciler) release(ctx context.Context, allocation *instancev1.InstanceAllocation) error {
+	var list instancev1.InstanceList
+	if err := r.reader().List(ctx, &list, client.InNamespace(allocation.Namespace)); err != nil {
+		return err
+	}
+	for i := range list.Items {
+		instance := &list.Items[i]
+		if !claimedBy(instance, allocation) {
+			continue
+		}
+		instance.Status.Allocation = nil
+		if err := r.Status().Update(ctx, instance); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *InstanceAllocationReconciler) reader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
+}
+
+func allocatable(instance *instancev1.Instance) bool {
+	return instance.DeletionTimestamp == nil &&
+		instance.Status.State == instancev1.StateRunning &&
+		instance.Status.Allocation == nil &&
+		!instance.Status.IsConditionTrue(instancev1.InstanceFull)
+}
+
+func claimedBy(instance *instancev1.Instance, allocation *instancev1.InstanceAllocation) bool {
+	return instance.Status.Allocation != nil && instance.Status.Allocation.UID == allocation.UID
+}
+
+func hasAllocationFinalizer(allocation *instancev1.InstanceAllocation) bool {
+	for _, f := range allocation.Finalizers {
+		if f == instancev1.AllocationFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *InstanceAllocationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(&instancev1.Instance{}, allocationUIDKey, func(o runtime.Object) []string {
+		instance := o.(*instancev1.Instance)
+		if instance.Status.Allocation == nil {
+			return nil
+		}
+		return []string{string(instance.Status.Allocation.UID)}
+	}); err != nil {
+		return err
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&instancev1.InstanceAllocation{}).
+		Complete(r)
+}
@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	instancev1 "github.com/cownetwork/instance-controller/api/v1"
+)
+
+func newAllocationTestReconciler(objs ...runtime.Object) *InstanceAllocationReconciler {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = instancev1.AddToScheme(scheme)
+	return &InstanceAllocationReconciler{
+		Client: fake.NewFakeClientWithScheme(scheme, objs...),
+		Log:    ctrl.Log.WithName("test"),
+		Scheme: scheme,
+	}
+}
+
+func runningInstance(name string, allocation *instancev1.InstanceAllocation) *instancev1.Instance {
+	instance := &instancev1.Instance{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"game": "lobby"}},
+		Status:     instancev1.InstanceStatus{ID: name, State: instancev1.StateRunning},
+	}
+	if allocation != nil {
+		instance.Status.Allocation = &instancev1.AllocationRef{Name: allocation.Name, UID: allocation.UID}
+	}
+	return instance
+}
+
+func testAllocation() *instancev1.InstanceAllocation {
+	return &instancev1.InstanceAllocation{
+		ObjectMeta: metav1.ObjectMeta{Name: "match", Namespace: "default", UID: types.UID("allocation")},
+		Spec: instancev1.InstanceAllocationSpec{
+			Selector: metav1.LabelSelector{MatchLabels: map[string]string{"game": "lobby"}},
+		},
+	}
+}
+
+// staleStatus is a client whose first status update conflicts, like one
+// using the resource version of an instance that changed in the meantime
+type staleStatus struct {
+	client.Client
+	conflicts int
+}
+
+func (c *staleStatus) Status() client.StatusWriter {
+	return &staleStatusWriter{StatusWriter: c.Client.Status(), client: c}
+}
+
+type staleStatusWriter struct {
+	client.StatusWriter
+	client *staleStatus
+}
+
+func (w *staleStatusWriter) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	if w.client.conflicts == 0 {
+		w.client.conflicts++
+		return apierrors.NewConflict(instancev1.GroupVersion.WithResource("instances").GroupResource(), "stale", errors.New("stale"))
+	}
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+func TestAllocationRetriesStaleInstance(t *testing.T) {
+	ctx := context.Background()
+	allocation := testAllocation()
+	r := newAllocationTestReconciler(allocation, runningInstance("lobby-a", nil))
+	stale := &staleStatus{Client: r.Client}
+	r.Client = stale
+	r.APIReader = stale.Client
+
+	key := client.ObjectKey{Namespace: "default", Name: allocation.Name}
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if stale.conflicts != 1 {
+		t.Fatalf("got %d conflicts, want the first claim to conflict", stale.conflicts)
+	}
+
+	if err := r.Get(ctx, key, allocation); err != nil {
+		t.Fatal(err)
+	}
+	if allocation.Status.State != instancev1.AllocationStateAllocated || allocation.Status.InstanceName != "lobby-a" {
+		t.Errorf("allocation status = %+v, want allocated lobby-a", allocation.Status)
+	}
+}
+
+func TestAllocationRecordsExistingClaim(t *testing.T) {
+	ctx := context.Background()
+	allocation := testAllocation()
+	// the claim was written, but recording it in the allocation failed
+	claimed := runningInstance("lobby-b", allocation)
+	claimed.Labels = nil
+	r := newAllocationTestReconciler(allocation, runningInstance("lobby-a", nil), claimed)
+
+	key := client.ObjectKey{Namespace: "default", Name: allocation.Name}
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	if err := r.Get(ctx, key, allocation); err != nil {
+		t.Fatal(err)
+	}
+	if allocation.Status.State != instancev1.AllocationStateAllocated || allocation.Status.InstanceName != claimed.Name {
+		t.Errorf("allocation status = %+v, want allocated %s", allocation.Status, claimed.Name)
+	}
+	if !hasAllocationFinalizer(allocation) {
+		t.Error("allocation has no finalizer")
+	}
+
+	var other instancev1.Instance
+	if err := r.Get(ctx, client.ObjectKey{Namespace: "default", Name: "lobby-a"}, &other); err != nil {
+		t.Fatal(err)
+	}
+	if other.Status.Allocation != nil {
+		t.Errorf("lobby-a has been claimed as well: %+v", other.Status.Allocation)
+	}
+}
+
+func TestDeletedAllocationReleasesClaim(t *testing.T) {
+	ctx := context.Background()
+	allocation := testAllocation()
+	now := metav1.Now()
+	allocation.DeletionTimestamp = &now
+	allocation.Finalizers = []string{instancev1.AllocationFinalizer}
+	r := newAllocationTestReconciler(allocation, runningInstance("lobby-a", allocation))
+
+	key := client.ObjectKey{Namespace: "default", Name: allocation.Name}
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	var instance instancev1.Instance
+	if err := r.Get(ctx, client.ObjectKey{Namespace: "default", Name: "lobby-a"}, &instance); err != nil {
+		t.Fatal(err)
+	}
+	if instance.Status.Allocation != nil {
+		t.Errorf("claim = %+v, want it to be released", instance.Status.Allocation)
+	}
+	var released instancev1.InstanceAllocation
+	if err := r.Get(ctx, key, &released); err != nil {
+		t.Fatal(err)
+	}
+	if hasAllocationFinalizer(&released) {
+		t.Error("finalizer has not been removed")
+	}
+}
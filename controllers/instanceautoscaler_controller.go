@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	instancev1 "github.com/cownetwork/instance-controller/api/v1"
+)
+
+// instanceSetNameKey indexes InstanceAutoscalers by the name of the InstanceSet they scale
+const instanceSetNameKey = ".spec.instanceSetName"
+
+// InstanceAutoscalerReconciler reconciles a InstanceAutoscaler object
+type InstanceAutoscalerReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=instance.cow.network,resources=instanceautoscalers,verbs=get;list;watch
+// +kubebuilder:rbac:groups=instance.cow.network,resources=instanceautoscalers/status,verbs=get;update;patch
+func (r *InstanceAutoscalerReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("autoscaler_name", req.Name, "namespace", req.Namespace)
+
+	var autoscaler instancev1.InstanceAutoscaler
+	if err := r.Get(ctx, req.NamespacedName, &autoscaler); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	var set instancev1.InstanceSet
+	key := client.ObjectKey{Name: autoscaler.Spec.InstanceSetName, Namespace: autoscaler.Namespace}
+	if err := r.Get(ctx, key, &set); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	var list instancev1.InstanceList
+	if err := r.List(ctx, &list, client.InNamespace(set.Namespace), client.MatchingFields{controllerOwnerKey: set.Name}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	var current, free int32
+	for i := range list.Items {
+		instance := &list.Items[i]
+		if !metav1.IsControlledBy(instance, &set) ||
+			instance.DeletionTimestamp != nil ||
+			instance.Status.State == instancev1.StateEnding {
+			continue
+		}
+		current++
+		if isFree(instance) {
+			free++
+		}
+	}
+
+	desired, err := desiredReplicas(&autoscaler.Spec, current, free)
+	if err != nil {
+		log.Error(err, "invalid autoscaler")
+		return ctrl.Result{}, nil
+	}
+
+	patch := client.MergeFrom(autoscaler.DeepCopy())
+	autoscaler.Status.CurrentReplicas = current
+	autoscaler.Status.FreeReplicas = free
+	autoscaler.Status.DesiredReplicas = desired
+
+	var result ctrl.Result
+	if desired != set.Spec.Replicas {
+		wait := scaleDownRemaining(&autoscaler, time.Now())
+		if desired < set.Spec.Replicas && wait > 0 {
+			result.RequeueAfter = wait
+		} else {
+			log.Info("scaling instance set", "instanceset_name", set.Name, "from", set.Spec.Replicas, "to", desired)
+			setPatch := client.MergeFrom(set.DeepCopy())
+			set.Spec.Replicas = desired
+			if err := r.Patch(ctx, &set, setPatch); err != nil {
+				return ctrl.Result{}, err
+			}
+			now := metav1.Now()
+			autoscaler.Status.LastScaleTime = &now
+		}
+	}
+
+	if err := r.Status().Patch(ctx, &autoscaler, patch); err != nil {
+		return ctrl.Result{}, err
+	}
+	return result, nil
+}
+
+// isFree reports whether the instance can take a new match or group of players.
+// Instances with a capacity are free as long as they are not full,
+// all others as long as no player is connected.
+func isFree(instance *instancev1.Instance) bool {
+	if instance.Status.Allocation != nil {
+		return false
+	}
+	if instance.Spec.Capacity != nil {
+		return !instance.Status.IsConditionTrue(instancev1.InstanceFull)
+	}
+	return len(instance.Status.Metadata.Players) == 0
+}
+
+// desiredReplicas computes the number of instances needed to keep the buffer of free instances.
+// The instances that are not free stay, on top of those either the absolute buffer is added, or
+// as many instances that the free ones make up the buffer percentage of all instances.
+// A non zero percentage always keeps at least one instance.
+func desiredReplicas(spec *instancev1.InstanceAutoscalerSpec, current, free int32) (int32, error) {
+	if spec.MinReplicas > spec.MaxReplicas {
+		return 0, fmt.Errorf("minReplicas %d is greater than maxReplicas %d", spec.MinReplicas, spec.MaxReplicas)
+	}
+	percent, relative, err := spec.BufferPercentage()
+	if err != nil {
+		return 0, fmt.Errorf("buffer %s %v", spec.Buffer.String(), err)
+	}
+
+	busy := current - free
+	var desired int32
+	if relative {
+		desired = int32(math.Ceil(float64(busy) * 100 / float64(100-percent)))
+		if percent > 0 && desired < 1 {
+			desired = 1
+		}
+	} else {
+		desired = busy + spec.Buffer.IntVal
+	}
+
+	if desired < spec.MinReplicas {
+		desired = spec.MinReplicas
+	}
+	if desired > spec.MaxReplicas {
+		desired = spec.MaxReplicas
+	}
+	return desired, nil
+}
+
+// scaleDownRemaining returns how long scaling down has to be delayed
+func scaleDownRemaining(autoscaler *instancev1.InstanceAutoscaler, now time.Time) time.Duration {
+	if autoscaler.Status.LastScaleTime == nil {
+		return 0
+	}
+
+	delay := int64(instancev1.DefaultScaleDownDelaySeconds)
+	if autoscaler.Spec.ScaleDownDelaySeconds != nil {
+		delay = *autoscaler.Spec.ScaleDownDelaySeconds
+	}
+
+	next := autoscaler.Status.LastScaleTime.Add(time.Duration(delay) * time.Second)
+	if remaining := next.Sub(now); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// autoscalersForInstance maps an Instance to the autoscalers of the set it belongs to
+func (r *InstanceAutoscalerReconciler) autoscalersForInstance(o handler.MapObject) []reconcile.Request {
+	owner := metav1.GetControllerOf(o.Meta)
+	if owner == nil || owner.Kind != "InstanceSet" {
+		return nil
+	}
+	return r.autoscalersForSetName(o.Meta.GetNamespace(), owner.Name)
+}
+
+// autoscalersForSet maps an InstanceSet to the autoscalers scaling it
+func (r *InstanceAutoscalerReconciler) autoscalersForSet(o handler.MapObject) []reconcile.Request {
+	return r.autoscalersForSetName(o.Meta.GetNamespace(), o.Meta.GetName())
+}
+
+func (r *InstanceAutoscalerReconciler) autoscalersForSetName(namespace, name string) []reconcile.Request {
+	var list instancev1.InstanceAutoscalerList
+	if err := r.List(context.Background(), &list,
+		client.InNamespace(namespace),
+		client.MatchingFields{instanceSetNameKey: name},
+	); err != nil {
+		r.Log.Error(err, "could not list autoscalers", "instanceset_name", name)
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(list.Items))
+	for _, autoscaler := range list.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: autoscaler.Name, Namespace: autoscaler.Namespace},
+		})
+	}
+	return requests
+}
+
+func (r *InstanceAutoscalerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(&instancev1.InstanceAutoscaler{}, instanceSetNameKey, func(o runtime.Object) []string {
+		autoscaler := o.(*instancev1.InstanceAutoscaler)
+		return []string{autoscaler.Spec.InstanceSetName}
+	}); err != nil {
+		return err
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&instancev1.InstanceAutoscaler{}).
+		Watches(
+			&source.Kind{Type: &instancev1.Instance{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.autoscalersForInstance)},
+		).
+		Watches(
+			&source.Kind{Type: &instancev1.InstanceSet{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.autoscalersForSet)},
+		).
+		Complete(r)
+}
@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	instancev1 "github.com/cownetwork/instance-controller/api/v1"
+)
+
+func TestDesiredReplicas(t *testing.T) {
+	tests := []struct {
+		name          string
+		buffer        intstr.IntOrString
+		min, max      int32
+		current, free int32
+		want          int32
+		wantErr       bool
+	}{
+		{name: "absolute buffer", buffer: intstr.FromInt(2), max: 10, current: 3, free: 1, want: 4},
+		{name: "percentage", buffer: intstr.FromString("20%"), max: 10, current: 4, free: 0, want: 5},
+		{name: "percentage without busy instances", buffer: intstr.FromString("20%"), max: 10, want: 1},
+		{name: "zero percentage", buffer: intstr.FromString("0%"), max: 10, want: 0},
+		{name: "min replicas", buffer: intstr.FromInt(1), min: 3, max: 10, want: 3},
+		{name: "max replicas", buffer: intstr.FromInt(5), max: 4, current: 2, want: 4},
+		{name: "invalid percentage", buffer: intstr.FromString("100%"), max: 10, wantErr: true},
+		{name: "min greater than max", buffer: intstr.FromInt(1), min: 5, max: 4, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &instancev1.InstanceAutoscalerSpec{Buffer: tt.buffer, MinReplicas: tt.min, MaxReplicas: tt.max}
+			got, err := desiredReplicas(spec, tt.current, tt.free)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("desiredReplicas() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("desiredReplicas() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	instancev1 "github.com/cownetwork/instance-controller/api/v1"
+)
+
+// InstanceSetReconciler reconciles a InstanceSet object
+type InstanceSetReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+
+	// expectations keeps the set from scaling again
+	// before its last changes are in the cache
+	expectations expectations
+}
+
+// +kubebuilder:rbac:groups=instance.cow.network,resources=instancesets,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=instance.cow.network,resources=instancesets/status,verbs=get;update;patch
+func (r *InstanceSetReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("instanceset_name", req.Name, "namespace", req.Namespace)
+
+	var set instancev1.InstanceSet
+	if err := r.Get(ctx, req.NamespacedName, &set); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.expectations.forget(req.NamespacedName)
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// the owned instances are removed by the garbage collector
+	if set.DeletionTimestamp != nil {
+		r.expectations.forget(req.NamespacedName)
+		return ctrl.Result{}, nil
+	}
+
+	instances, err := r.ownedInstances(ctx, &set)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Instances that are ending are on their way out and will be replaced by new ones.
+	// The Instance controller removes them once their pod terminated for good.
+	active := make([]instancev1.Instance, 0, len(instances))
+	for _, instance := range instances {
+		if instance.DeletionTimestamp == nil && instance.Status.State != instancev1.StateEnding {
+			active = append(active, instance)
+		}
+	}
+
+	// The instances we created or deleted last time may not be in the cache yet,
+	// we get called again once they are
+	if !r.expectations.satisfied(req.NamespacedName, instances) {
+		log.V(1).Info("waiting for created and deleted instances to be observed")
+		return ctrl.Result{RequeueAfter: expectationsTimeout}, nil
+	}
+
+	diff := int(set.Spec.Replicas) - len(active)
+	switch {
+	case diff > 0:
+		log.Info("scaling up", "replicas", len(active), "desired", set.Spec.Replicas)
+		for i := 0; i < diff; i++ {
+			instance, err := r.newInstance(&set)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if err := r.Create(ctx, instance); err != nil {
+				return ctrl.Result{}, err
+			}
+			r.expectations.expectCreate(req.NamespacedName, instance.Name)
+			active = append(active, *instance)
+		}
+	case diff < 0:
+		log.Info("scaling down", "replicas", len(active), "desired", set.Spec.Replicas)
+		sortForDeletion(active)
+		for i := 0; i < -diff; i++ {
+			if err := r.Delete(ctx, &active[i]); err != nil {
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+				return ctrl.Result{}, err
+			}
+			r.expectations.expectDelete(req.NamespacedName, active[i].UID)
+		}
+		active = active[-diff:]
+	}
+
+	patch := client.MergeFrom(set.DeepCopy())
+	set.Status.Replicas = int32(len(active))
+	set.Status.ReadyReplicas = 0
+	for _, instance := range active {
+		if instance.Status.State == instancev1.StateRunning {
+			set.Status.ReadyReplicas++
+		}
+	}
+	if err := r.Status().Patch(ctx, &set, patch); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// ownedInstances lists all Instances controlled by the set
+func (r *InstanceSetReconciler) ownedInstances(ctx context.Context, set *instancev1.InstanceSet) ([]instancev1.Instance, error) {
+	var list instancev1.InstanceList
+	if err := r.List(ctx, &list, client.InNamespace(set.Namespace), client.MatchingFields{controllerOwnerKey: set.Name}); err != nil {
+		return nil, err
+	}
+
+	instances := make([]instancev1.Instance, 0, len(list.Items))
+	for _, instance := range list.Items {
+		if metav1.IsControlledBy(&instance, set) {
+			instances = append(instances, instance)
+		}
+	}
+	return instances, nil
+}
+
+func (r *InstanceSetReconciler) newInstance(set *instancev1.InstanceSet) (*instancev1.Instance, error) {
+	instance := &instancev1.Instance{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: set.Name + "-",
+			Namespace:    set.Namespace,
+			Labels:       make(map[string]string),
+			Annotations:  make(map[string]string),
+		},
+		Spec: *set.Spec.Template.Spec.DeepCopy(),
+	}
+
+	for k, v := range set.Spec.Template.Metadata.Annotations {
+		instance.Annotations[k] = v
+	}
+
+	for k, v := range set.Spec.Template.Metadata.Labels {
+		instance.Labels[k] = v
+	}
+	instance.Labels[instancev1.InstanceSetLabel] = set.Name
+
+	if err := ctrl.SetControllerReference(set, instance, r.Scheme); err != nil {
+		return nil, err
+	}
+
+	return instance, nil
+}
+
+// sortForDeletion sorts the instances so that the ones that should be deleted first come first.
+// Instances that are not Running yet are deleted before Running ones, Running instances
+// without players before the ones with players, and emptier instances before fuller ones.
+// Allocated instances are deleted last. Younger instances are preferred otherwise.
+func sortForDeletion(instances []instancev1.Instance) {
+	sort.SliceStable(instances, func(i, j int) bool {
+		a, b := &instances[i], &instances[j]
+		if ra, rb := deletionRank(a), deletionRank(b); ra != rb {
+			return ra < rb
+		}
+		if pa, pb := len(a.Status.Metadata.Players), len(b.Status.Metadata.Players); pa != pb {
+			return pa < pb
+		}
+		return b.CreationTimestamp.Before(&a.CreationTimestamp)
+	})
+}
+
+func deletionRank(instance *instancev1.Instance) int {
+	switch {
+	case instance.Status.Allocation != nil:
+		return 3
+	case instance.Status.State != instancev1.StateRunning:
+		return 0
+	case len(instance.Status.Metadata.Players) == 0:
+		return 1
+	}
+	return 2
+}
+
+func (r *InstanceSetReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(&instancev1.Instance{}, controllerOwnerKey, func(o runtime.Object) []string {
+		instance := o.(*instancev1.Instance)
+		owner := metav1.GetControllerOf(instance)
+		if owner == nil {
+			return nil
+		}
+		if owner.APIVersion != instancev1.GroupVersion.String() || owner.Kind != "InstanceSet" {
+			return nil
+		}
+		return []string{owner.Name}
+	}); err != nil {
+		return err
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&instancev1.InstanceSet{}).
+		Owns(&instancev1.Instance{}).
+		Complete(r)
+}
@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	instancev1 "github.com/cownetwork/instance-controller/api/v1"
+)
+
+// generateNames is a client that names objects created with a generated name,
+// which the fake client does not do
+type generateNames struct {
+	client.Client
+	n int
+}
+
+func (c *generateNames) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	if instance, ok := obj.(*instancev1.Instance); ok && len(instance.Name) == 0 {
+		c.n++
+		instance.Name = fmt.Sprintf("%s%d", instance.GenerateName, c.n)
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func newSetTestReconciler(t *testing.T, replicas int32, members ...*instancev1.Instance) (*InstanceSetReconciler, *instancev1.InstanceSet) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = instancev1.AddToScheme(scheme)
+
+	set := &instancev1.InstanceSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "lobby", Namespace: "default", UID: types.UID("0d7c2b4e-5f6a-4b8c-9d0e-1f2a3b4c5d6e")},
+		Spec:       instancev1.InstanceSetSpec{Replicas: replicas},
+	}
+	objs := []runtime.Object{set}
+	for _, member := range members {
+		member.Namespace = set.Namespace
+		if err := ctrl.SetControllerReference(set, member, scheme); err != nil {
+			t.Fatal(err)
+		}
+		objs = append(objs, member)
+	}
+
+	r := &InstanceSetReconciler{
+		Client: &generateNames{Client: fake.NewFakeClientWithScheme(scheme, objs...)},
+		Log:    ctrl.Log.WithName("test"),
+		Scheme: scheme,
+	}
+	return r, set
+}
+
+func setMember(name string, state instancev1.InstanceState, players int) *instancev1.Instance {
+	instance := &instancev1.Instance{ObjectMeta: metav1.ObjectMeta{Name: name, UID: types.UID(name)}}
+	instance.Status.State = state
+	for i := 0; i < players; i++ {
+		instance.Status.Metadata.Players = append(instance.Status.Metadata.Players, instancev1.InstancePlayer{ID: fmt.Sprint(i)})
+	}
+	return instance
+}
+
+func memberNames(t *testing.T, r *InstanceSetReconciler, set *instancev1.InstanceSet) map[string]bool {
+	instances, err := r.ownedInstances(context.Background(), set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool, len(instances))
+	for _, instance := range instances {
+		names[instance.Name] = true
+	}
+	return names
+}
+
+func TestInstanceSetScaleUpIgnoresEndingMembers(t *testing.T) {
+	r, set := newSetTestReconciler(t, 2,
+		setMember("running", instancev1.StateRunning, 0),
+		setMember("ending", instancev1.StateEnding, 0),
+	)
+
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: set.Namespace, Name: set.Name}}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	names := memberNames(t, r, set)
+	if len(names) != 3 || !names["running"] || !names["ending"] {
+		t.Errorf("members = %v, want a replacement for the ending instance besides [ending running]", names)
+	}
+
+	if err := r.Get(context.Background(), types.NamespacedName{Namespace: set.Namespace, Name: set.Name}, set); err != nil {
+		t.Fatal(err)
+	}
+	if set.Status.Replicas != 2 || set.Status.ReadyReplicas != 1 {
+		t.Errorf("Status = %d replicas, %d ready, want 2 replicas, 1 ready", set.Status.Replicas, set.Status.ReadyReplicas)
+	}
+}
+
+func TestInstanceSetScaleDownIgnoresEndingMembers(t *testing.T) {
+	r, set := newSetTestReconciler(t, 1,
+		setMember("full", instancev1.StateRunning, 2),
+		setMember("empty", instancev1.StateRunning, 0),
+		setMember("ending", instancev1.StateEnding, 0),
+	)
+
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: set.Namespace, Name: set.Name}}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	// the ending instance is removed by the instance controller once its pod terminated
+	names := memberNames(t, r, set)
+	if len(names) != 2 || !names["full"] || !names["ending"] {
+		t.Errorf("members = %v, want the empty instance to be deleted", names)
+	}
+}
+
+func TestSortForDeletion(t *testing.T) {
+	now := time.Now()
+	instances := []instancev1.Instance{
+		*setMember("allocated", instancev1.StateRunning, 0),
+		*setMember("full", instancev1.StateRunning, 2),
+		*setMember("joined", instancev1.StateRunning, 1),
+		*setMember("empty-old", instancev1.StateRunning, 0),
+		*setMember("empty-young", instancev1.StateRunning, 0),
+		*setMember("initializing", instancev1.StateInitializing, 0),
+	}
+	instances[0].Status.Allocation = &instancev1.AllocationRef{Name: "match"}
+	for i := range instances {
+		instances[i].CreationTimestamp = metav1.NewTime(now.Add(time.Duration(i) * time.Second))
+	}
+
+	sortForDeletion(instances)
+	want := []string{"initializing", "empty-young", "empty-old", "joined", "full", "allocated"}
+	for i, instance := range instances {
+		if instance.Name != want[i] {
+			t.Fatalf("instance %d = %s, want the order %v", i, instance.Name, want)
+		}
+	}
+}
+
+func TestDeletionRank(t *testing.T) {
+	allocated := setMember("allocated", instancev1.StateRunning, 1)
+	allocated.Status.Allocation = &instancev1.AllocationRef{Name: "match"}
+
+	tests := []struct {
+		instance *instancev1.Instance
+		want     int
+	}{
+		{setMember("initializing", instancev1.StateInitializing, 0), 0},
+		{setMember("empty", instancev1.StateRunning, 0), 1},
+		{setMember("joined", instancev1.StateRunning, 1), 2},
+		{allocated, 3},
+	}
+	for _, tt := range tests {
+		if got := deletionRank(tt.instance); got != tt.want {
+			t.Errorf("deletionRank(%s) = %d, want %d", tt.instance.Name, got, tt.want)
+		}
+	}
+}
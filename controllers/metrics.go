@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	instancev1 "github.com/cownetwork/instance-controller/api/v1"
+)
+
+var (
+	// instanceStartupSeconds measures the time from the creation of an instance until it is Running
+	instanceStartupSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cow_instance_startup_seconds",
+		Help:    "Time from the creation of an instance until it is running.",
+		Buckets: []float64{1, 2.5, 5, 10, 20, 30, 60, 120, 300},
+	}, []string{"namespace"})
+
+	// podsLost counts the pods of instances that disappeared before they were seen to terminate
+	podsLost = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cow_instance_pods_lost_total",
+		Help: "Number of pods of instances that disappeared before they were seen to terminate.",
+	}, []string{"namespace"})
+
+	// eventsEmitted counts the emitted events by type and result, which is
+	// success, queued if the event waits for redelivery or failure if it is lost
+	eventsEmitted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cow_instance_events_emitted_total",
+		Help: "Number of emitted events by type and result (success, queued or failure).",
+	}, []string{"type", "result"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(instanceStartupSeconds, podsLost, eventsEmitted)
+}
+
+var (
+	instancesDesc = prometheus.NewDesc(
+		"cow_instances",
+		"Number of instances by namespace and state.",
+		[]string{"namespace", "state"}, nil,
+	)
+	instancePlayersDesc = prometheus.NewDesc(
+		"cow_instance_players",
+		"Number of players connected to an instance.",
+		[]string{"namespace", "instance"}, nil,
+	)
+	playersDesc = prometheus.NewDesc(
+		"cow_players",
+		"Number of players connected to all instances.",
+		nil, nil,
+	)
+)
+
+// InstanceCollector collects the number of instances and their players
+// from the instances in the cache on every scrape
+type InstanceCollector struct {
+	Client client.Reader
+}
+
+var _ prometheus.Collector = &InstanceCollector{}
+
+// Describe implements prometheus.Collector
+func (c *InstanceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- instancesDesc
+	ch <- instancePlayersDesc
+	ch <- playersDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *InstanceCollector) Collect(ch chan<- prometheus.Metric) {
+	var list instancev1.InstanceList
+	if err := c.Client.List(context.Background(), &list); err != nil {
+		ch <- prometheus.NewInvalidMetric(instancesDesc, err)
+		return
+	}
+
+	type key struct {
+		namespace string
+		state     instancev1.InstanceState
+	}
+	instances := make(map[key]int)
+	players := 0
+	for _, instance := range list.Items {
+		instances[key{instance.Namespace, instance.Status.State}]++
+		count := len(instance.Status.Metadata.Players)
+		players += count
+		ch <- prometheus.MustNewConstMetric(instancePlayersDesc, prometheus.GaugeValue, float64(count), instance.Namespace, instance.Name)
+	}
+	for k, count := range instances {
+		ch <- prometheus.MustNewConstMetric(instancesDesc, prometheus.GaugeValue, float64(count), k.namespace, string(k.state))
+	}
+	ch <- prometheus.MustNewConstMetric(playersDesc, prometheus.GaugeValue, float64(players))
+}
@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	instancev1 "github.com/cownetwork/instance-controller/api/v1"
+)
+
+func TestInstanceCollector(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := instancev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	newInstance := func(name string, state instancev1.InstanceState, players ...string) *instancev1.Instance {
+		instance := &instancev1.Instance{}
+		instance.Name = name
+		instance.Namespace = "games"
+		instance.Status.State = state
+		for _, id := range players {
+			instance.Status.Metadata.Players = append(instance.Status.Metadata.Players, instancev1.InstancePlayer{ID: id})
+		}
+		return instance
+	}
+	c := fake.NewFakeClientWithScheme(scheme,
+		newInstance("lobby-1", instancev1.StateRunning, "steve", "alex"),
+		newInstance("lobby-2", instancev1.StateRunning, "notch"),
+		newInstance("lobby-3", instancev1.StateInitializing),
+	)
+
+	expected := `
+# HELP cow_instance_players Number of players connected to an instance.
+# TYPE cow_instance_players gauge
+cow_instance_players{instance="lobby-1",namespace="games"} 2
+cow_instance_players{instance="lobby-2",namespace="games"} 1
+cow_instance_players{instance="lobby-3",namespace="games"} 0
+# HELP cow_instances Number of instances by namespace and state.
+# TYPE cow_instances gauge
+cow_instances{namespace="games",state="Initializing"} 1
+cow_instances{namespace="games",state="Running"} 2
+# HELP cow_players Number of players connected to all instances.
+# TYPE cow_players gauge
+cow_players 3
+`
+	if err := testutil.CollectAndCompare(&InstanceCollector{Client: c}, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,12 +1,17 @@
 package controllers
 
 import (
+	"context"
+	"encoding/json"
 	"path/filepath"
 	"testing"
+	"time"
 
 	instancev1 "github.com/cownetwork/instance-controller/api/v1"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -55,9 +60,13 @@ var _ = BeforeSuite(func(done Done) {
 		Scheme: scheme.Scheme,
 	})
 
+	Expect(err).ToNot(HaveOccurred())
+
 	err = (&InstanceReconciler{
-		Client: k8sManager.GetClient(),
-		Log:    ctrl.Log.WithName("controller").WithName("Instance"),
+		Client:  k8sManager.GetClient(),
+		Log:     ctrl.Log.WithName("controller").WithName("Instance"),
+		Scheme:  k8sManager.GetScheme(),
+		Decider: NewDefaultDecider(k8sManager.GetClient(), k8sManager.GetAPIReader()),
 	}).SetupWithManager(k8sManager)
 	Expect(err).ToNot(HaveOccurred())
 
@@ -77,3 +86,81 @@ var _ = AfterSuite(func() {
 	err := testEnv.Stop()
 	Expect(err).ToNot(HaveOccurred())
 })
+
+var _ = Describe("Instance status", func() {
+	const timeout = time.Second * 10
+	const interval = time.Millisecond * 250
+	ctx := context.Background()
+
+	newInstance := func(name string) *instancev1.Instance {
+		return &instancev1.Instance{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: instancev1.InstanceSpec{
+				Template: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "game", Image: "game:latest"}},
+				},
+			},
+		}
+	}
+
+	waitForInit := func(key client.ObjectKey) *instancev1.Instance {
+		instance := &instancev1.Instance{}
+		Eventually(func() instancev1.InstanceState {
+			_ = k8sClient.Get(ctx, key, instance)
+			return instance.Status.State
+		}, timeout, interval).Should(Equal(instancev1.StateInitializing))
+		Expect(instance.Status.ID).NotTo(BeEmpty())
+		return instance
+	}
+
+	It("is only written through the status subresource", func() {
+		instance := newInstance("status-subresource")
+		Expect(k8sClient.Create(ctx, instance)).To(Succeed())
+		key := client.ObjectKey{Name: instance.Name, Namespace: instance.Namespace}
+		instance = waitForInit(key)
+		id := instance.Status.ID
+
+		By("updating the spec with a modified status")
+		instance.Labels = map[string]string{"mode": "lobby"}
+		instance.Status.ID = "hand-written"
+		Expect(k8sClient.Update(ctx, instance)).To(Succeed())
+
+		updated := &instancev1.Instance{}
+		Eventually(func() map[string]string {
+			_ = k8sClient.Get(ctx, key, updated)
+			return updated.Labels
+		}, timeout, interval).Should(HaveKeyWithValue("mode", "lobby"))
+		Expect(updated.Status.ID).To(Equal(id))
+	})
+
+	It("keeps the metadata reported by the application", func() {
+		instance := newInstance("status-metadata")
+		Expect(k8sClient.Create(ctx, instance)).To(Succeed())
+		key := client.ObjectKey{Name: instance.Name, Namespace: instance.Namespace}
+		instance = waitForInit(key)
+
+		By("reporting metadata as the application")
+		patch := client.MergeFrom(instance.DeepCopy())
+		instance.Status.Metadata.State = json.RawMessage(`{"map":"castle"}`)
+		Expect(k8sClient.Status().Patch(ctx, instance, patch)).To(Succeed())
+
+		By("making the pod ready")
+		pod := &corev1.Pod{}
+		Eventually(func() error {
+			return k8sClient.Get(ctx, client.ObjectKey{Name: instance.Status.ID, Namespace: instance.Namespace}, pod)
+		}, timeout, interval).Should(Succeed())
+		pod.Status.Phase = corev1.PodRunning
+		pod.Status.PodIP = "10.0.0.1"
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		pod.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: "game", Image: "game:latest", Ready: true}}
+		Expect(k8sClient.Status().Update(ctx, pod)).To(Succeed())
+
+		updated := &instancev1.Instance{}
+		Eventually(func() instancev1.InstanceState {
+			_ = k8sClient.Get(ctx, key, updated)
+			return updated.Status.State
+		}, timeout, interval).Should(Equal(instancev1.StateRunning))
+		Expect(updated.Status.IP).To(Equal("10.0.0.1"))
+		Expect(string(updated.Status.Metadata.State)).To(MatchJSON(`{"map":"castle"}`))
+	})
+})
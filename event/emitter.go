@@ -5,8 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 
-	"github.com/Shopify/sarama"
-	kafka "github.com/cloudevents/sdk-go/protocol/kafka_sarama/v2"
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	instancev1 "github.com/cownetwork/instance-controller/api/v1"
 	instanceapiv1 "github.com/cownetwork/mooapis-go/cow/instance/v1"
@@ -15,34 +13,27 @@ import (
 	"google.golang.org/protobuf/types/known/structpb"
 )
 
+// Types of the emitted events
+const (
+	TypeInstanceStarted      = "network.cow.instance.started.v1"
+	TypeInstanceEnded        = "network.cow.instance.ended.v1"
+	TypeInstanceStateChanged = "network.cow.instance.state-changed.v1"
+	TypePlayerJoined         = "network.cow.instance.player-joined.v1"
+	TypePlayerLeft           = "network.cow.instance.player-left.v1"
+)
+
 type Emitter struct {
-	c      cloudevents.Client
-	sender *kafka.Sender
+	sink   Sink
 	source string
 }
 
-// NewEmitter creates an new Emitter that emitts events in the cloud event Kafka format
-// to the configured Kafka brokers
-func NewEmitter(brokers []string, topic, source string) (*Emitter, error) {
-	const op = "events/NewPublisher"
-	config := sarama.NewConfig()
-	config.Version = sarama.V2_0_0_0
-
-	sender, err := kafka.NewSender(brokers, config, topic)
-	if err != nil {
-		return nil, fmt.Errorf("%s: %v", op, err)
-	}
-
-	c, err := cloudevents.NewClient(sender, cloudevents.WithTimeNow(), cloudevents.WithUUIDs())
-	if err != nil {
-		return nil, fmt.Errorf("%s: %v", op, err)
-	}
-
+// NewEmitter creates an new Emitter that emitts instance events in the cloud event format
+// to the given Sink
+func NewEmitter(sink Sink, source string) *Emitter {
 	return &Emitter{
-		c:      c,
-		sender: sender,
+		sink:   sink,
 		source: source,
-	}, nil
+	}
 }
 
 // InstanceCreated emitts an InstanceCreatedEvent
@@ -50,23 +41,20 @@ func (e *Emitter) InstanceCreated(ctx context.Context, instance *instancev1.Inst
 	const op = "event/emitter.InstanceCreated"
 	protoinstance, err := instanceToProto(instance)
 	if err != nil {
-		return fmt.Errorf("%s: %v", op, err)
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
 	msg := &instanceapiv1.InstanceStartedEvent{
 		Instance: protoinstance,
 	}
 
-	event, err := makeCloudEvent("network.cow.instance.started.v1", e.source, msg)
+	event, err := makeCloudEvent(TypeInstanceStarted, e.source, msg)
 	if err != nil {
-		return fmt.Errorf("%s: %v", op, err)
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	if result := e.c.Send(
-		kafka.WithMessageKey(ctx, sarama.StringEncoder(event.ID())),
-		event,
-	); cloudevents.IsUndelivered(result) {
-		return fmt.Errorf("%s: failed to send: %v", op, err)
+	if err := e.sink.Send(ctx, event); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
 	}
 	return nil
 }
@@ -76,23 +64,20 @@ func (e *Emitter) InstanceEnded(ctx context.Context, instance *instancev1.Instan
 	const op = "event/emitter.InstanceEnded"
 	protoinstance, err := instanceToProto(instance)
 	if err != nil {
-		return fmt.Errorf("%s: %v", op, err)
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
 	msg := &instanceapiv1.InstanceEndedEvent{
 		Instance: protoinstance,
 	}
 
-	event, err := makeCloudEvent("network.cow.instance.ended.v1", e.source, msg)
+	event, err := makeCloudEvent(TypeInstanceEnded, e.source, msg)
 	if err != nil {
-		return fmt.Errorf("%s: %v", op, err)
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	if result := e.c.Send(
-		kafka.WithMessageKey(ctx, sarama.StringEncoder(event.ID())),
-		event,
-	); cloudevents.IsUndelivered(result) {
-		return fmt.Errorf("%s: failed to send: %v", op, err)
+	if err := e.sink.Send(ctx, event); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
 	}
 	return nil
 }
@@ -107,17 +92,17 @@ func (e *Emitter) InstanceStateChanged(
 	const op = "event/emitter.InstanceStateChanged"
 	protoinstance, err := instanceToProto(instance)
 	if err != nil {
-		return fmt.Errorf("%s: %v", op, err)
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
 	oldstate, err := toStructpb(old)
 	if err != nil {
-		return fmt.Errorf("%s: %v", op, err)
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
 	newstate, err := toStructpb(new)
 	if err != nil {
-		return fmt.Errorf("%s: %v", op, err)
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
 	msg := &instanceapiv1.InstanceStateChangedEvent{
@@ -126,16 +111,57 @@ func (e *Emitter) InstanceStateChanged(
 		NewState: newstate,
 	}
 
-	event, err := makeCloudEvent("network.cow.instance.state-changed.v1", e.source, msg)
+	event, err := makeCloudEvent(TypeInstanceStateChanged, e.source, msg)
 	if err != nil {
-		return fmt.Errorf("%s: %v", op, err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := e.sink.Send(ctx, event); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
 	}
+	return nil
+}
 
-	if result := e.c.Send(
-		kafka.WithMessageKey(ctx, sarama.StringEncoder(event.ID())),
-		event,
-	); cloudevents.IsUndelivered(result) {
-		return fmt.Errorf("%s: failed to send: %v", op, err)
+// PlayerJoined emitts a player joined event carrying the player.
+// The subject of the event is the ID of the instance.
+func (e *Emitter) PlayerJoined(ctx context.Context, instance *instancev1.Instance, player instancev1.InstancePlayer) error {
+	const op = "event/emitter.PlayerJoined"
+	if err := e.sendPlayerEvent(ctx, TypePlayerJoined, instance, player); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// PlayerLeft emitts a player left event carrying the player.
+// The subject of the event is the ID of the instance.
+func (e *Emitter) PlayerLeft(ctx context.Context, instance *instancev1.Instance, player instancev1.InstancePlayer) error {
+	const op = "event/emitter.PlayerLeft"
+	if err := e.sendPlayerEvent(ctx, TypePlayerLeft, instance, player); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (e *Emitter) sendPlayerEvent(
+	ctx context.Context,
+	eventtype string,
+	instance *instancev1.Instance,
+	player instancev1.InstancePlayer,
+) error {
+	const op = "event/emitter.sendPlayerEvent"
+	msg, err := toAPIPlayer(player)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	event, err := makeCloudEvent(eventtype, e.source, msg)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	event.SetSubject(instance.Status.ID)
+
+	if err := e.sink.Send(ctx, event); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
 	}
 	return nil
 }
@@ -145,7 +171,7 @@ func makeCloudEvent(eventtype, source string, msg proto.Message) (cloudevents.Ev
 	event := cloudevents.NewEvent()
 	id, err := uuid.NewRandom()
 	if err != nil {
-		return cloudevents.Event{}, fmt.Errorf("%s: %v", op, err)
+		return cloudevents.Event{}, fmt.Errorf("%s: %w", op, err)
 	}
 
 	event.SetID(id.String())
@@ -154,11 +180,11 @@ func makeCloudEvent(eventtype, source string, msg proto.Message) (cloudevents.Ev
 
 	data, err := proto.Marshal(msg)
 	if err != nil {
-		return cloudevents.Event{}, fmt.Errorf("%s: %v", op, err)
+		return cloudevents.Event{}, fmt.Errorf("%s: %w", op, err)
 	}
 
 	if err := event.SetData("application/protobuf", data); err != nil {
-		return cloudevents.Event{}, fmt.Errorf("%s: %v", op, err)
+		return cloudevents.Event{}, fmt.Errorf("%s: %w", op, err)
 	}
 
 	return event, nil
@@ -169,7 +195,7 @@ func instanceToProto(instance *instancev1.Instance) (*instanceapiv1.Instance, er
 
 	structval, err := toStructpb(instance.Status.Metadata.State)
 	if err != nil {
-		return nil, fmt.Errorf("%s: %v", op, err)
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
 	data := &instanceapiv1.Instance{
@@ -185,7 +211,7 @@ func instanceToProto(instance *instancev1.Instance) (*instanceapiv1.Instance, er
 	for _, p := range instance.Status.Metadata.Players {
 		apiplayer, err := toAPIPlayer(p)
 		if err != nil {
-			return nil, fmt.Errorf("%s: %v", op, err)
+			return nil, fmt.Errorf("%s: %w", op, err)
 		}
 		players = append(players, apiplayer)
 	}
@@ -197,7 +223,7 @@ func toAPIPlayer(player instancev1.InstancePlayer) (*instanceapiv1.Player, error
 	const op = "event/toAPIPlayer"
 	structval, err := toStructpb(player.Metadata)
 	if err != nil {
-		return nil, fmt.Errorf("%s: %v", op, err)
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 	return &instanceapiv1.Player{
 		Id:       player.ID,
@@ -219,13 +245,17 @@ func toAPIState(state instancev1.InstanceState) instanceapiv1.Instance_State {
 
 func toStructpb(raw json.RawMessage) (*structpb.Struct, error) {
 	const op = "event/toStructpb"
+	// the metadata is optional and not set until the application reports it
+	if len(raw) == 0 {
+		return &structpb.Struct{Fields: map[string]*structpb.Value{}}, nil
+	}
 	var data map[string]interface{}
 	if err := json.Unmarshal(raw, &data); err != nil {
-		return nil, fmt.Errorf("%s: %v", op, err)
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 	structval, err := structpb.NewStruct(data)
 	if err != nil {
-		return nil, fmt.Errorf("%s: %v", op, err)
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 	return structval, nil
 }
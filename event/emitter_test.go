@@ -0,0 +1,135 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	instancev1 "github.com/cownetwork/instance-controller/api/v1"
+	instanceapiv1 "github.com/cownetwork/mooapis-go/cow/instance/v1"
+	"github.com/golang/protobuf/proto"
+)
+
+func testInstance() *instancev1.Instance {
+	instance := &instancev1.Instance{}
+	instance.Name = "lobby"
+	instance.Status.ID = "b3c6d2ca-1b1a-4a47-a3a4-52d7a0a5a8c3"
+	instance.Status.State = instancev1.StateRunning
+	instance.Status.Metadata.State = json.RawMessage(`{"map":"castle"}`)
+	instance.Status.Metadata.Players = []instancev1.InstancePlayer{{ID: "steve"}}
+	return instance
+}
+
+func TestEmitterInstanceCreated(t *testing.T) {
+	sink := &MemorySink{}
+	emitter := NewEmitter(sink, "test")
+
+	if err := emitter.InstanceCreated(context.Background(), testInstance()); err != nil {
+		t.Fatalf("InstanceCreated() error = %v", err)
+	}
+
+	events := sink.Events()
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	if events[0].Type() != "network.cow.instance.started.v1" || events[0].Source() != "test" {
+		t.Errorf("got event type %q from %q", events[0].Type(), events[0].Source())
+	}
+
+	var msg instanceapiv1.InstanceStartedEvent
+	if err := proto.Unmarshal(events[0].Data(), &msg); err != nil {
+		t.Fatalf("could not unmarshal event data: %v", err)
+	}
+	if msg.Instance.Id != testInstance().Status.ID || len(msg.Instance.Metadata.Players) != 1 {
+		t.Errorf("unexpected instance in event: %v", msg.Instance)
+	}
+}
+
+func TestEmitterWithoutMetadata(t *testing.T) {
+	sink := &MemorySink{}
+	instance := testInstance()
+	instance.Status.Metadata = instancev1.InstanceMetadata{}
+
+	if err := NewEmitter(sink, "test").InstanceEnded(context.Background(), instance); err != nil {
+		t.Fatalf("InstanceEnded() error = %v", err)
+	}
+}
+
+func TestEmitterSinkError(t *testing.T) {
+	sink := &MemorySink{Err: errors.New("broker unavailable")}
+
+	if err := NewEmitter(sink, "test").InstanceEnded(context.Background(), testInstance()); err == nil {
+		t.Fatal("InstanceEnded() error = nil, want sink error")
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	tests := []struct {
+		name       string
+		structured bool
+		status     int
+		wantErr    bool
+	}{
+		{name: "binary", status: http.StatusAccepted},
+		{name: "structured", structured: true, status: http.StatusOK},
+		{name: "rejected", status: http.StatusInternalServerError, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var contentType, eventType string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				contentType = r.Header.Get("Content-Type")
+				eventType = r.Header.Get("Ce-Type")
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			sink, err := NewHTTPSink(srv.URL, tt.structured)
+			if err != nil {
+				t.Fatalf("NewHTTPSink() error = %v", err)
+			}
+
+			err = NewEmitter(sink, "test").InstanceCreated(context.Background(), testInstance())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("InstanceCreated() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.structured {
+				if contentType != "application/cloudevents+json" {
+					t.Errorf("got content type %q, want structured cloud event", contentType)
+				}
+			} else if eventType != "network.cow.instance.started.v1" {
+				t.Errorf("got ce-type header %q, want binary cloud event", eventType)
+			}
+		})
+	}
+}
+
+func TestEmitterPlayerJoined(t *testing.T) {
+	sink := &MemorySink{}
+	player := instancev1.InstancePlayer{ID: "alex", Metadata: json.RawMessage(`{"team":"red"}`)}
+
+	if err := NewEmitter(sink, "test").PlayerJoined(context.Background(), testInstance(), player); err != nil {
+		t.Fatalf("PlayerJoined() error = %v", err)
+	}
+
+	events := sink.Events()
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	if events[0].Type() != "network.cow.instance.player-joined.v1" || events[0].Subject() != testInstance().Status.ID {
+		t.Errorf("got event type %q with subject %q", events[0].Type(), events[0].Subject())
+	}
+
+	var msg instanceapiv1.Player
+	if err := proto.Unmarshal(events[0].Data(), &msg); err != nil {
+		t.Fatalf("could not unmarshal event data: %v", err)
+	}
+	if msg.Id != "alex" || msg.Metadata.Fields["team"].GetStringValue() != "red" {
+		t.Errorf("unexpected player in event: %v", &msg)
+	}
+}
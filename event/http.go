@@ -0,0 +1,49 @@
+package event
+
+import (
+	"context"
+	"fmt"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// HTTPSink POSTs events to a webhook URL using the
+// cloud event HTTP protocol binding
+type HTTPSink struct {
+	c          cloudevents.Client
+	structured bool
+}
+
+// NewHTTPSink creates a new HTTPSink sending events to target.
+// Events are sent in binary content mode unless structured is set.
+func NewHTTPSink(target string, structured bool) (*HTTPSink, error) {
+	const op = "event/NewHTTPSink"
+	protocol, err := cloudevents.NewHTTP(cloudevents.WithTarget(target))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", op, err)
+	}
+
+	c, err := cloudevents.NewClient(protocol, cloudevents.WithTimeNow(), cloudevents.WithUUIDs())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", op, err)
+	}
+
+	return &HTTPSink{
+		c:          c,
+		structured: structured,
+	}, nil
+}
+
+// Send POSTs the event. Every response other than 2xx is considered a failure.
+func (s *HTTPSink) Send(ctx context.Context, event cloudevents.Event) error {
+	const op = "event/HTTPSink.Send"
+	if s.structured {
+		ctx = cloudevents.WithEncodingStructured(ctx)
+	} else {
+		ctx = cloudevents.WithEncodingBinary(ctx)
+	}
+	if result := s.c.Send(ctx, event); !cloudevents.IsACK(result) {
+		return fmt.Errorf("%s: failed to send: %v", op, result)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package event
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	kafka "github.com/cloudevents/sdk-go/protocol/kafka_sarama/v2"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// KafkaSink sends events in the cloud event Kafka format
+// to the configured Kafka brokers
+type KafkaSink struct {
+	c      cloudevents.Client
+	sender *kafka.Sender
+}
+
+// NewKafkaSink creates a new KafkaSink sending events to the given topic
+func NewKafkaSink(brokers []string, topic string) (*KafkaSink, error) {
+	const op = "event/NewKafkaSink"
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_0_0_0
+
+	sender, err := kafka.NewSender(brokers, config, topic)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", op, err)
+	}
+
+	c, err := cloudevents.NewClient(sender, cloudevents.WithTimeNow(), cloudevents.WithUUIDs())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", op, err)
+	}
+
+	return &KafkaSink{
+		c:      c,
+		sender: sender,
+	}, nil
+}
+
+// Send sends the event keyed by its ID
+func (s *KafkaSink) Send(ctx context.Context, event cloudevents.Event) error {
+	const op = "event/KafkaSink.Send"
+	if result := s.c.Send(
+		kafka.WithMessageKey(ctx, sarama.StringEncoder(event.ID())),
+		event,
+	); !cloudevents.IsACK(result) {
+		return fmt.Errorf("%s: failed to send: %v", op, result)
+	}
+	return nil
+}
+
+// Close closes the underlying Kafka producer
+func (s *KafkaSink) Close(ctx context.Context) error {
+	return s.sender.Close(ctx)
+}
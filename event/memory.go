@@ -0,0 +1,45 @@
+package event
+
+import (
+	"context"
+	"sync"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// MemorySink records all events in memory.
+// It is meant to be used in tests.
+type MemorySink struct {
+	mu     sync.Mutex
+	events []cloudevents.Event
+
+	// Err is returned by Send instead of recording the event if set
+	Err error
+}
+
+// Send records the event
+func (s *MemorySink) Send(ctx context.Context, event cloudevents.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Err != nil {
+		return s.Err
+	}
+	s.events = append(s.events, event.Clone())
+	return nil
+}
+
+// Events returns all recorded events in the order they were sent
+func (s *MemorySink) Events() []cloudevents.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := make([]cloudevents.Event, len(s.events))
+	copy(events, s.events)
+	return events
+}
+
+// Reset removes all recorded events
+func (s *MemorySink) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = nil
+}
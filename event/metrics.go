@@ -0,0 +1,30 @@
+package event
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// outboxStoreErrors counts the failed operations on the Store of the Outbox
+	outboxStoreErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cow_event_outbox_store_errors_total",
+		Help: "Number of failed operations on the store of undelivered events by operation.",
+	}, []string{"operation"})
+
+	// outboxRedeliveries counts the redelivery attempts of pending events by type and result
+	outboxRedeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cow_event_outbox_redeliveries_total",
+		Help: "Number of redelivery attempts of undelivered events by type and result (success or failure).",
+	}, []string{"type", "result"})
+
+	// outboxEvicted counts the pending events dropped because the Store was full
+	outboxEvicted = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "cow_event_outbox_evicted_total",
+		Help: "Number of undelivered events dropped because the store was full.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(outboxStoreErrors, outboxRedeliveries, outboxEvicted)
+}
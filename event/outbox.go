@@ -0,0 +1,181 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/go-logr/logr"
+)
+
+const (
+	defaultMinBackoff = 5 * time.Second
+	defaultMaxBackoff = 10 * time.Minute
+	defaultInterval   = 5 * time.Second
+)
+
+// ErrQueued is returned by Outbox.Send if the event could not be delivered
+// right away and has been recorded for redelivery instead
+var ErrQueued = errors.New("event queued for redelivery")
+
+// PendingEvent is an event recorded in the Outbox that
+// has not been acknowledged by the Sink yet
+type PendingEvent struct {
+	Event cloudevents.Event `json:"event"`
+
+	// Recorded is the time the event was first recorded
+	Recorded time.Time `json:"recorded"`
+
+	// Attempts is the number of failed delivery attempts
+	Attempts int `json:"attempts"`
+
+	// NextAttempt is the earliest time the event will be re-sent
+	NextAttempt time.Time `json:"nextAttempt"`
+}
+
+// Store durably records pending events
+type Store interface {
+	// Put adds or replaces the pending events with the same event IDs
+	Put(ctx context.Context, pending ...PendingEvent) error
+
+	// Delete removes the pending events with the given event IDs
+	Delete(ctx context.Context, ids ...string) error
+
+	// List returns all pending events
+	List(ctx context.Context) ([]PendingEvent, error)
+}
+
+// Outbox is a Sink that records the events the underlying Sink could not deliver
+// in a Store. Recorded events are re-sent with exponential backoff until the Sink
+// acknowledges them. Delivered events never touch the Store, and the events delivered
+// by a Flush are removed from it at once.
+// Outbox implements manager.Runnable to run the redelivery loop.
+type Outbox struct {
+	sink  Sink
+	store Store
+	log   logr.Logger
+	now   func() time.Time
+
+	// MinBackoff is the delay before the first redelivery of an event
+	MinBackoff time.Duration
+
+	// MaxBackoff caps the delay between redeliveries of an event
+	MaxBackoff time.Duration
+
+	// Interval is the period in which the Store is checked for due events
+	Interval time.Duration
+}
+
+// NewOutbox creates a new Outbox delivering events to sink
+func NewOutbox(sink Sink, store Store, log logr.Logger) *Outbox {
+	return &Outbox{
+		sink:       sink,
+		store:      store,
+		log:        log,
+		now:        time.Now,
+		MinBackoff: defaultMinBackoff,
+		MaxBackoff: defaultMaxBackoff,
+		Interval:   defaultInterval,
+	}
+}
+
+// Send tries to deliver the event right away. If the delivery fails the event
+// is recorded and re-sent later on, and an error wrapping ErrQueued is returned.
+// The event is lost only if neither the delivery nor recording it succeeds.
+func (o *Outbox) Send(ctx context.Context, event cloudevents.Event) error {
+	const op = "event/Outbox.Send"
+	err := o.sink.Send(ctx, event)
+	if err == nil {
+		return nil
+	}
+
+	now := o.now()
+	pending := PendingEvent{
+		Event:       event,
+		Recorded:    now,
+		Attempts:    1,
+		NextAttempt: now.Add(o.backoff(1)),
+	}
+	if perr := o.store.Put(ctx, pending); perr != nil {
+		outboxStoreErrors.WithLabelValues("put").Inc()
+		o.log.Error(perr, "could not record undelivered event", "event_id", event.ID(), "event_type", event.Type())
+		return fmt.Errorf("%s: event lost, delivery failed: %v", op, err)
+	}
+	o.log.Info("queued event for redelivery", "event_id", event.ID(), "event_type", event.Type(), "error", err.Error())
+	return fmt.Errorf("%s: %w: %v", op, ErrQueued, err)
+}
+
+// Start re-sends due events until stop is closed
+func (o *Outbox) Start(stop <-chan struct{}) error {
+	ticker := time.NewTicker(o.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			o.Flush(context.Background())
+		}
+	}
+}
+
+// Flush re-sends all pending events that are due. The Store is updated
+// once for all delivered and once for all rescheduled events.
+func (o *Outbox) Flush(ctx context.Context) {
+	pending, err := o.store.List(ctx)
+	if err != nil {
+		outboxStoreErrors.WithLabelValues("list").Inc()
+		o.log.Error(err, "could not list pending events")
+		return
+	}
+
+	now := o.now()
+	var delivered []string
+	var rescheduled []PendingEvent
+	for _, p := range pending {
+		if p.NextAttempt.After(now) {
+			continue
+		}
+		if err := o.sink.Send(ctx, p.Event); err != nil {
+			outboxRedeliveries.WithLabelValues(p.Event.Type(), "failure").Inc()
+			o.log.Error(err, "could not redeliver event",
+				"event_id", p.Event.ID(),
+				"event_type", p.Event.Type(),
+				"attempts", p.Attempts,
+			)
+			p.Attempts++
+			p.NextAttempt = now.Add(o.backoff(p.Attempts))
+			rescheduled = append(rescheduled, p)
+			continue
+		}
+		outboxRedeliveries.WithLabelValues(p.Event.Type(), "success").Inc()
+		delivered = append(delivered, p.Event.ID())
+	}
+
+	if len(delivered) > 0 {
+		if err := o.store.Delete(ctx, delivered...); err != nil {
+			// the events will be delivered again, which is better than losing them
+			outboxStoreErrors.WithLabelValues("delete").Inc()
+			o.log.Error(err, "could not remove delivered events", "events", len(delivered))
+		}
+	}
+	if len(rescheduled) > 0 {
+		if err := o.store.Put(ctx, rescheduled...); err != nil {
+			outboxStoreErrors.WithLabelValues("put").Inc()
+			o.log.Error(err, "could not reschedule events", "events", len(rescheduled))
+		}
+	}
+}
+
+func (o *Outbox) backoff(attempts int) time.Duration {
+	backoff := o.MinBackoff
+	for i := 1; i < attempts && backoff < o.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > o.MaxBackoff {
+		return o.MaxBackoff
+	}
+	return backoff
+}
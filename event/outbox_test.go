@@ -0,0 +1,220 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func newTestOutbox(sink Sink) (*Outbox, *ConfigMapStore, *time.Time) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	store := NewConfigMapStore(
+		fake.NewFakeClientWithScheme(scheme),
+		types.NamespacedName{Namespace: "default", Name: "outbox"},
+	)
+
+	now := time.Now()
+	outbox := NewOutbox(sink, store, zap.New(zap.UseDevMode(true)))
+	outbox.now = func() time.Time { return now }
+	return outbox, store, &now
+}
+
+func TestOutboxDeliversImmediately(t *testing.T) {
+	ctx := context.Background()
+	sink := &MemorySink{}
+	outbox, store, _ := newTestOutbox(sink)
+
+	if err := NewEmitter(outbox, "test").InstanceCreated(ctx, testInstance()); err != nil {
+		t.Fatalf("InstanceCreated() error = %v", err)
+	}
+
+	if got := len(sink.Events()); got != 1 {
+		t.Errorf("got %d delivered events, want 1", got)
+	}
+	pending, err := store.List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("got %d pending events, want 0", len(pending))
+	}
+}
+
+func TestOutboxRedeliversWithBackoff(t *testing.T) {
+	ctx := context.Background()
+	sink := &MemorySink{Err: errors.New("broker unavailable")}
+	outbox, store, now := newTestOutbox(sink)
+
+	if err := NewEmitter(outbox, "test").InstanceEnded(ctx, testInstance()); !errors.Is(err, ErrQueued) {
+		t.Fatalf("InstanceEnded() error = %v, want the event to be queued", err)
+	}
+
+	pending, err := store.List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(pending) != 1 || pending[0].Attempts != 1 {
+		t.Fatalf("got pending events %+v, want one with a single attempt", pending)
+	}
+	if want := now.Add(outbox.MinBackoff); !pending[0].NextAttempt.Equal(want) {
+		t.Errorf("got next attempt %v, want %v", pending[0].NextAttempt, want)
+	}
+
+	// a second failure doubles the backoff
+	failures := testutil.ToFloat64(outboxRedeliveries.WithLabelValues(TypeInstanceEnded, "failure"))
+	*now = now.Add(outbox.MinBackoff)
+	outbox.Flush(ctx)
+	if got := testutil.ToFloat64(outboxRedeliveries.WithLabelValues(TypeInstanceEnded, "failure")); got != failures+1 {
+		t.Errorf("counted %v failed redeliveries, want 1", got-failures)
+	}
+	pending, _ = store.List(ctx)
+	if want := now.Add(2 * outbox.MinBackoff); len(pending) != 1 || !pending[0].NextAttempt.Equal(want) {
+		t.Fatalf("got pending events %+v, want next attempt at %v", pending, want)
+	}
+
+	// not due yet
+	sink.Err = nil
+	outbox.Flush(ctx)
+	if got := len(sink.Events()); got != 0 {
+		t.Fatalf("got %d delivered events before the backoff expired, want 0", got)
+	}
+
+	successes := testutil.ToFloat64(outboxRedeliveries.WithLabelValues(TypeInstanceEnded, "success"))
+	*now = now.Add(2 * outbox.MinBackoff)
+	outbox.Flush(ctx)
+	if got := testutil.ToFloat64(outboxRedeliveries.WithLabelValues(TypeInstanceEnded, "success")); got != successes+1 {
+		t.Errorf("counted %v successful redeliveries, want 1", got-successes)
+	}
+	events := sink.Events()
+	if len(events) != 1 || events[0].Type() != "network.cow.instance.ended.v1" {
+		t.Fatalf("got delivered events %v, want the ended event", events)
+	}
+	pending, _ = store.List(ctx)
+	if len(pending) != 0 {
+		t.Errorf("got %d pending events after delivery, want 0", len(pending))
+	}
+}
+
+// failingStore is a Store that fails every operation
+type failingStore struct{}
+
+func (failingStore) Put(ctx context.Context, pending ...PendingEvent) error {
+	return errors.New("store unavailable")
+}
+
+func (failingStore) Delete(ctx context.Context, ids ...string) error {
+	return errors.New("store unavailable")
+}
+
+func (failingStore) List(ctx context.Context) ([]PendingEvent, error) {
+	return nil, errors.New("store unavailable")
+}
+
+func TestOutboxDeliversWithoutStore(t *testing.T) {
+	ctx := context.Background()
+	sink := &MemorySink{}
+	outbox := NewOutbox(sink, failingStore{}, zap.New(zap.UseDevMode(true)))
+
+	if err := NewEmitter(outbox, "test").InstanceCreated(ctx, testInstance()); err != nil {
+		t.Fatalf("InstanceCreated() error = %v", err)
+	}
+	if got := len(sink.Events()); got != 1 {
+		t.Errorf("got %d delivered events, want 1", got)
+	}
+
+	sink.Err = errors.New("broker unavailable")
+	if err := NewEmitter(outbox, "test").InstanceCreated(ctx, testInstance()); err == nil || errors.Is(err, ErrQueued) {
+		t.Errorf("InstanceCreated() error = %v, want an error if the event is lost", err)
+	}
+}
+
+func TestConfigMapStoreEvictsOldestEvents(t *testing.T) {
+	ctx := context.Background()
+	_, store, now := newTestOutbox(&MemorySink{})
+	store.MaxEvents = 2
+
+	for i, id := range []string{"a", "b", "c"} {
+		event := cloudevents.NewEvent()
+		event.SetID(id)
+		event.SetSource("test")
+		event.SetType(TypeInstanceStarted)
+		if err := store.Put(ctx, PendingEvent{Event: event, Recorded: now.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatalf("Put(%s) error = %v", id, err)
+		}
+	}
+
+	pending, err := store.List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	var ids []string
+	for _, p := range pending {
+		ids = append(ids, p.Event.ID())
+	}
+	sort.Strings(ids)
+	if len(ids) != 2 || ids[0] != "b" || ids[1] != "c" {
+		t.Errorf("got pending events %v, want [b c]", ids)
+	}
+}
+
+func TestConfigMapStoreEvictsBySize(t *testing.T) {
+	ctx := context.Background()
+	_, store, now := newTestOutbox(&MemorySink{})
+	store.MaxBytes = 64 * 1024
+
+	// every event carries the metadata of many players
+	metadata := `{"players":"` + strings.Repeat("x", 10*1024) + `"}`
+	for i := 0; i < 10; i++ {
+		event := cloudevents.NewEvent()
+		event.SetID(fmt.Sprint(i))
+		event.SetSource("test")
+		event.SetType(TypeInstanceStateChanged)
+		if err := event.SetData(cloudevents.ApplicationJSON, json.RawMessage(metadata)); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Put(ctx, PendingEvent{Event: event, Recorded: now.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatalf("Put(%d) error = %v, want old events to be evicted", i, err)
+		}
+	}
+
+	var cm corev1.ConfigMap
+	if err := store.client.Get(ctx, store.key, &cm); err != nil {
+		t.Fatal(err)
+	}
+	size := 0
+	for id, data := range cm.Data {
+		size += len(id) + len(data)
+	}
+	if size > store.MaxBytes {
+		t.Errorf("stored %d bytes, want at most %d", size, store.MaxBytes)
+	}
+	if _, ok := cm.Data["9"]; !ok || len(cm.Data) == 10 {
+		t.Errorf("got %d stored events, want the newest ones to be kept and the oldest evicted", len(cm.Data))
+	}
+	if _, ok := cm.Data["0"]; ok {
+		t.Error("the oldest event was kept")
+	}
+}
+
+func TestOutboxBackoffIsCapped(t *testing.T) {
+	outbox, _, _ := newTestOutbox(&MemorySink{})
+	if got := outbox.backoff(100); got != outbox.MaxBackoff {
+		t.Errorf("backoff(100) = %v, want %v", got, outbox.MaxBackoff)
+	}
+}
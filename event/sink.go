@@ -0,0 +1,14 @@
+package event
+
+import (
+	"context"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// Sink delivers cloud events to their destination
+type Sink interface {
+	// Send delivers the event. An error is returned if the
+	// event could not be delivered.
+	Send(ctx context.Context, event cloudevents.Event) error
+}
@@ -0,0 +1,184 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// defaultMaxBytes keeps the ConfigMap below its size limit of 1MiB,
+	// leaving room for its metadata
+	defaultMaxBytes = 900 * 1024
+
+	// defaultMaxEvents limits the number of events the Outbox lists on every Flush
+	defaultMaxEvents = 500
+)
+
+// ConfigMapStore is a Store that keeps pending events in a ConfigMap.
+// Every event is stored under its ID. The ConfigMap is created on demand.
+type ConfigMapStore struct {
+	client client.Client
+	key    types.NamespacedName
+
+	// MaxBytes is the maximum size of the encoded pending events. If it is
+	// exceeded the events recorded first are evicted.
+	MaxBytes int
+
+	// MaxEvents is the maximum number of pending events. If it is exceeded
+	// the events recorded first are evicted.
+	MaxEvents int
+}
+
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;create;update
+
+// NewConfigMapStore creates a new ConfigMapStore using the ConfigMap identified by key
+func NewConfigMapStore(c client.Client, key types.NamespacedName) *ConfigMapStore {
+	return &ConfigMapStore{client: c, key: key, MaxBytes: defaultMaxBytes, MaxEvents: defaultMaxEvents}
+}
+
+// Put adds or replaces the pending events and evicts the oldest
+// events if they take more than MaxBytes or there are more than MaxEvents
+func (s *ConfigMapStore) Put(ctx context.Context, pending ...PendingEvent) error {
+	const op = "event/ConfigMapStore.Put"
+	data := make(map[string]string, len(pending))
+	for _, p := range pending {
+		d, err := json.Marshal(p)
+		if err != nil {
+			return fmt.Errorf("%s: %v", op, err)
+		}
+		data[p.Event.ID()] = string(d)
+	}
+
+	var evicted int
+	if err := s.update(ctx, func(cm *corev1.ConfigMap) {
+		for id, d := range data {
+			cm.Data[id] = d
+		}
+		evicted = s.evict(cm)
+	}); err != nil {
+		return fmt.Errorf("%s: %v", op, err)
+	}
+	outboxEvicted.Add(float64(evicted))
+	return nil
+}
+
+// Delete removes the pending events
+func (s *ConfigMapStore) Delete(ctx context.Context, ids ...string) error {
+	const op = "event/ConfigMapStore.Delete"
+	if err := s.update(ctx, func(cm *corev1.ConfigMap) {
+		for _, id := range ids {
+			delete(cm.Data, id)
+		}
+	}); err != nil {
+		return fmt.Errorf("%s: %v", op, err)
+	}
+	return nil
+}
+
+// List returns all pending events ordered by their next attempt
+func (s *ConfigMapStore) List(ctx context.Context) ([]PendingEvent, error) {
+	const op = "event/ConfigMapStore.List"
+	var cm corev1.ConfigMap
+	if err := s.client.Get(ctx, s.key, &cm); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s: %v", op, err)
+	}
+
+	pending := make([]PendingEvent, 0, len(cm.Data))
+	for id, data := range cm.Data {
+		var p PendingEvent
+		if err := json.Unmarshal([]byte(data), &p); err != nil {
+			return nil, fmt.Errorf("%s: malformed event %s: %v", op, id, err)
+		}
+		pending = append(pending, p)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].NextAttempt.Before(pending[j].NextAttempt)
+	})
+	return pending, nil
+}
+
+// evict removes the events recorded first until the remaining ones take at most MaxBytes
+// and there are at most MaxEvents left. It returns the number of removed events.
+// Malformed events are removed first.
+func (s *ConfigMapStore) evict(cm *corev1.ConfigMap) int {
+	size := 0
+	for id, data := range cm.Data {
+		size += len(id) + len(data)
+	}
+	full := func(events int) bool {
+		return s.MaxBytes > 0 && size > s.MaxBytes || s.MaxEvents > 0 && events > s.MaxEvents
+	}
+	if !full(len(cm.Data)) {
+		return 0
+	}
+
+	type recorded struct {
+		id   string
+		time time.Time
+	}
+	events := make([]recorded, 0, len(cm.Data))
+	for id, data := range cm.Data {
+		var p PendingEvent
+		// malformed events keep the zero time
+		_ = json.Unmarshal([]byte(data), &p)
+		events = append(events, recorded{id: id, time: p.Recorded})
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].time.Before(events[j].time)
+	})
+
+	evicted := 0
+	for _, e := range events {
+		if !full(len(cm.Data)) {
+			break
+		}
+		size -= len(e.id) + len(cm.Data[e.id])
+		delete(cm.Data, e.id)
+		evicted++
+	}
+	return evicted
+}
+
+// update applies mutate to the ConfigMap, creating it if necessary
+func (s *ConfigMapStore) update(ctx context.Context, mutate func(cm *corev1.ConfigMap)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var cm corev1.ConfigMap
+		err := s.client.Get(ctx, s.key, &cm)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+
+		if apierrors.IsNotFound(err) {
+			cm.Name = s.key.Name
+			cm.Namespace = s.key.Namespace
+			cm.Data = make(map[string]string)
+			mutate(&cm)
+			if err := s.client.Create(ctx, &cm); err != nil {
+				if apierrors.IsAlreadyExists(err) {
+					// someone else created it in the meantime, retry as a conflict
+					return apierrors.NewConflict(corev1.Resource("configmaps"), s.key.Name, err)
+				}
+				return err
+			}
+			return nil
+		}
+
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		mutate(&cm)
+		return s.client.Update(ctx, &cm)
+	})
+}
@@ -3,17 +3,20 @@ module github.com/cownetwork/instance-controller
 go 1.16
 
 require (
-	github.com/Shopify/sarama v1.28.0 // indirect
-	github.com/cloudevents/sdk-go/protocol/kafka_sarama/v2 v2.4.1 // indirect
-	github.com/cloudevents/sdk-go/v2 v2.4.1 // indirect
-	github.com/cownetwork/mooapis-go v0.16.9 // indirect
+	github.com/Shopify/sarama v1.28.0
+	github.com/cloudevents/sdk-go/protocol/kafka_sarama/v2 v2.4.1
+	github.com/cloudevents/sdk-go/v2 v2.4.1
+	github.com/cownetwork/mooapis-go v0.16.9
 	github.com/go-logr/logr v0.1.0
-	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/golang/protobuf v1.5.2
 	github.com/google/uuid v1.2.0
 	github.com/onsi/ginkgo v1.11.0
 	github.com/onsi/gomega v1.8.1
+	github.com/prometheus/client_golang v1.0.0
 	golang.org/x/sys v0.0.0-20210124154548-22da62e12c0c // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
+	google.golang.org/grpc v1.43.0 // indirect
+	google.golang.org/protobuf v1.26.0
 	gopkg.in/jcmturner/aescts.v1 v1.0.1 // indirect
 	gopkg.in/jcmturner/dnsutils.v1 v1.0.1 // indirect
 	gopkg.in/jcmturner/goidentity.v3 v3.0.0 // indirect
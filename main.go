@@ -1,17 +1,25 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"os"
+	"strings"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	instancev1 "github.com/cownetwork/instance-controller/api/v1"
 	"github.com/cownetwork/instance-controller/controllers"
+	"github.com/cownetwork/instance-controller/event"
+	"github.com/cownetwork/instance-controller/playerapi"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -30,10 +38,41 @@ func init() {
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var eventBrokers string
+	var eventTopic string
+	var eventSource string
+	var eventHTTPURL string
+	var eventHTTPStructured bool
+	var outboxNamespace string
+	var outboxName string
+	var outboxMaxEvents int
+	var instanceDefaultsName string
+	var playerAPIAddr string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&eventBrokers, "event-brokers", "",
+		"Comma separated list of Kafka brokers instance lifecycle events are sent to.")
+	flag.StringVar(&eventTopic, "event-topic", "cow.instance", "The Kafka topic instance lifecycle events are sent to.")
+	flag.StringVar(&eventSource, "event-source", "instance-controller", "The source set on emitted cloud events.")
+	flag.StringVar(&eventHTTPURL, "event-http-url", "",
+		"Webhook URL instance lifecycle events are POSTed to instead of Kafka. "+
+			"Events are disabled if neither brokers nor an URL are given.")
+	flag.BoolVar(&eventHTTPStructured, "event-http-structured", false,
+		"Send events to the webhook in structured instead of binary content mode.")
+	flag.StringVar(&outboxNamespace, "event-outbox-namespace", os.Getenv("POD_NAMESPACE"),
+		"Namespace of the ConfigMap undelivered events are kept in. Defaults to the namespace of the controller.")
+	flag.StringVar(&outboxName, "event-outbox-name", "instance-controller-event-outbox",
+		"Name of the ConfigMap undelivered events are kept in.")
+	flag.IntVar(&outboxMaxEvents, "event-outbox-max-events", 500,
+		"Maximum number of undelivered events kept in the ConfigMap, which also keeps them below 900KiB. "+
+			"The oldest events are dropped first.")
+	flag.StringVar(&instanceDefaultsName, "instance-defaults-name", "default",
+		"Name of the cluster-scoped InstanceDefaults object defaults of new instances are taken from.")
+	flag.StringVar(&playerAPIAddr, "player-api-addr", "",
+		"The address the player API binds to. The API is disabled if it is empty. "+
+			"Tokens are derived from the secret in the PLAYER_API_SECRET environment variable.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
@@ -50,20 +89,134 @@ func main() {
 		os.Exit(1)
 	}
 
+	var emitter *event.Emitter
+	sink, err := newEventSink(eventBrokers, eventTopic, eventHTTPURL, eventHTTPStructured)
+	if err != nil {
+		setupLog.Error(err, "unable to create event sink")
+		os.Exit(1)
+	}
+	if sink != nil {
+		outbox, err := newEventOutbox(mgr, sink, outboxNamespace, outboxName, outboxMaxEvents)
+		if err != nil {
+			setupLog.Error(err, "unable to create event outbox")
+			os.Exit(1)
+		}
+		if err := mgr.Add(outbox); err != nil {
+			setupLog.Error(err, "unable to add event outbox to manager")
+			os.Exit(1)
+		}
+		emitter = event.NewEmitter(outbox, eventSource)
+	} else {
+		setupLog.Info("no event sink configured, instance lifecycle events are disabled")
+	}
+
+	var playerAPISecret []byte
+	if len(playerAPIAddr) > 0 {
+		playerAPISecret = []byte(os.Getenv("PLAYER_API_SECRET"))
+		if len(playerAPISecret) == 0 {
+			setupLog.Error(errors.New("PLAYER_API_SECRET is not set"), "unable to create player api")
+			os.Exit(1)
+		}
+		if err = (&playerapi.Server{
+			Client:    mgr.GetClient(),
+			Log:       ctrl.Log.WithName("playerapi"),
+			APIReader: mgr.GetAPIReader(),
+			Addr:      playerAPIAddr,
+			Secret:    playerAPISecret,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create player api")
+			os.Exit(1)
+		}
+	}
+
 	if err = (&controllers.InstanceReconciler{
-		Client:  mgr.GetClient(),
-		Log:     ctrl.Log.WithName("controllers").WithName("Instance"),
-		Scheme:  mgr.GetScheme(),
-		Decider: controllers.Decider{Client: mgr.GetClient()},
+		Client:      mgr.GetClient(),
+		Log:         ctrl.Log.WithName("controllers").WithName("Instance"),
+		Scheme:      mgr.GetScheme(),
+		Decider:     controllers.NewDefaultDecider(mgr.GetClient(), mgr.GetAPIReader()),
+		Emitter:     emitter,
+		Recorder:    mgr.GetEventRecorderFor("instance-controller"),
+		TokenSecret: playerAPISecret,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Instance")
 		os.Exit(1)
 	}
+	if err = (&controllers.InstanceSetReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("InstanceSet"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "InstanceSet")
+		os.Exit(1)
+	}
+	if err = (&controllers.InstanceAllocationReconciler{
+		Client:    mgr.GetClient(),
+		Log:       ctrl.Log.WithName("controllers").WithName("InstanceAllocation"),
+		Scheme:    mgr.GetScheme(),
+		APIReader: mgr.GetAPIReader(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "InstanceAllocation")
+		os.Exit(1)
+	}
+	if err = (&controllers.InstanceAutoscalerReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("InstanceAutoscaler"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "InstanceAutoscaler")
+		os.Exit(1)
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&instancev1.Instance{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Instance")
+			os.Exit(1)
+		}
+		if err = (&instancev1.InstanceAutoscaler{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "InstanceAutoscaler")
+			os.Exit(1)
+		}
+		mgr.GetWebhookServer().Register(instancev1.InstanceDefaulterPath, &webhook.Admission{
+			Handler: &instancev1.InstanceDefaulter{Client: mgr.GetClient(), Name: instanceDefaultsName},
+		})
+	}
 	// +kubebuilder:scaffold:builder
 
+	metrics.Registry.MustRegister(&controllers.InstanceCollector{Client: mgr.GetClient()})
+
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
 }
+
+// newEventSink creates the Sink lifecycle events are delivered to.
+// It returns nil if no sink is configured.
+func newEventSink(brokers, topic, url string, structured bool) (event.Sink, error) {
+	switch {
+	case len(brokers) > 0 && len(url) > 0:
+		return nil, errors.New("only one of --event-brokers and --event-http-url can be set")
+	case len(brokers) > 0:
+		return event.NewKafkaSink(strings.Split(brokers, ","), topic)
+	case len(url) > 0:
+		return event.NewHTTPSink(url, structured)
+	}
+	return nil, nil
+}
+
+// newEventOutbox creates the Outbox that makes sure events are delivered
+// to sink even if it is temporarily unavailable.
+func newEventOutbox(mgr ctrl.Manager, sink event.Sink, namespace, name string, maxEvents int) (*event.Outbox, error) {
+	if len(namespace) == 0 {
+		return nil, errors.New("--event-outbox-namespace must be set if POD_NAMESPACE is not")
+	}
+	// don't use the cached client of the manager, we don't want to
+	// watch every ConfigMap in the cluster for a single one
+	c, err := client.New(mgr.GetConfig(), client.Options{Scheme: mgr.GetScheme(), Mapper: mgr.GetRESTMapper()})
+	if err != nil {
+		return nil, err
+	}
+	store := event.NewConfigMapStore(c, types.NamespacedName{Namespace: namespace, Name: name})
+	store.MaxEvents = maxEvents
+	return event.NewOutbox(sink, store, ctrl.Log.WithName("event").WithName("Outbox")), nil
+}
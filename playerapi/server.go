@@ -0,0 +1,282 @@
+// Package playerapi implements the HTTP API game servers use to report the players
+// connected to their Instance and the state of the application.
+//
+// Every request is authenticated by the ID of the Instance in the X-Instance-Id header
+// and the token handed to the pod in the INSTANCE_TOKEN environment variable as bearer token.
+// Changes are merged into the status of the Instance, retrying on conflicts, so concurrent
+// requests never overwrite each other. The InstanceReconciler emits the events for the changes
+// once it observes them.
+package playerapi
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	instancev1 "github.com/cownetwork/instance-controller/api/v1"
+)
+
+// instanceIDKey indexes Instances by their ID
+const instanceIDKey = ".status.id"
+
+// maxBodySize limits the size of request bodies
+const maxBodySize = 64 << 10
+
+var errNotFound = errors.New("instance not found")
+
+// Token returns the token an Instance with the given ID authenticates with
+func Token(secret []byte, id string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Server serves the player API
+type Server struct {
+	Client client.Client
+	Log    logr.Logger
+
+	// APIReader reads Instances bypassing the cache, so retries after a conflict
+	// see the latest version of the Instance. The Client is used if it is nil.
+	APIReader client.Reader
+
+	// Addr is the address the server listens on
+	Addr string
+
+	// Secret the tokens of the Instances are derived from
+	Secret []byte
+}
+
+// SetupWithManager registers the index used to look up Instances by their ID and
+// adds the server to the manager
+func (s *Server) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(&instancev1.Instance{}, instanceIDKey, func(o runtime.Object) []string {
+		instance := o.(*instancev1.Instance)
+		if len(instance.Status.ID) == 0 {
+			return nil
+		}
+		return []string{instance.Status.ID}
+	}); err != nil {
+		return err
+	}
+	return mgr.Add(s)
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. The API is served by every
+// replica of the controller, so requests don't fail while the leader changes.
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable
+func (s *Server) Start(stop <-chan struct{}) error {
+	srv := &http.Server{Addr: s.Addr, Handler: s.Handler()}
+
+	errs := make(chan error, 1)
+	go func() {
+		s.Log.Info("starting player api", "addr", s.Addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			errs <- err
+		}
+		close(errs)
+	}()
+
+	select {
+	case err, ok := <-errs:
+		if ok {
+			return err
+		}
+		return nil
+	case <-stop:
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return srv.Shutdown(ctx)
+	}
+}
+
+// Handler returns the handler serving the API
+//
+//	POST /v1/players/join  {"id": "...", "metadata": {...}}
+//	POST /v1/players/leave {"id": "..."}
+//	PUT  /v1/state         {...}
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/v1/players/join", s.authenticated(http.MethodPost, s.playerJoined))
+	mux.Handle("/v1/players/leave", s.authenticated(http.MethodPost, s.playerLeft))
+	mux.Handle("/v1/state", s.authenticated(http.MethodPut, s.setState))
+	return mux
+}
+
+type handlerFunc func(ctx context.Context, id string, body json.RawMessage) (int, error)
+
+func (s *Server) authenticated(method string, h handlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != method {
+			w.Header().Set("Allow", method)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		id := req.Header.Get("X-Instance-Id")
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if len(id) == 0 || !hmac.Equal([]byte(token), []byte(Token(s.Secret, id))) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		var body json.RawMessage
+		if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxBodySize)).Decode(&body); err != nil {
+			http.Error(w, "request body must be JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		status, err := h(req.Context(), id, body)
+		if err != nil {
+			s.Log.Error(err, "could not handle request", "path", req.URL.Path, "instance_id", id)
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.WriteHeader(status)
+	})
+}
+
+func (s *Server) playerJoined(ctx context.Context, id string, body json.RawMessage) (int, error) {
+	var player instancev1.InstancePlayer
+	if err := json.Unmarshal(body, &player); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if len(player.ID) == 0 {
+		return http.StatusBadRequest, errors.New("id of the player is missing")
+	}
+	if len(player.Metadata) > 0 && !isObject(player.Metadata) {
+		return http.StatusBadRequest, errors.New("metadata of the player must be a JSON object")
+	}
+
+	_, err := s.updateStatus(ctx, id, func(status *instancev1.InstanceStatus) {
+		for i := range status.Metadata.Players {
+			if status.Metadata.Players[i].ID == player.ID {
+				status.Metadata.Players[i].Metadata = player.Metadata
+				return
+			}
+		}
+		status.Metadata.Players = append(status.Metadata.Players, player)
+	})
+	if err != nil {
+		return statusFor(err), err
+	}
+	return http.StatusNoContent, nil
+}
+
+func (s *Server) playerLeft(ctx context.Context, id string, body json.RawMessage) (int, error) {
+	var player instancev1.InstancePlayer
+	if err := json.Unmarshal(body, &player); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	_, err := s.updateStatus(ctx, id, func(status *instancev1.InstanceStatus) {
+		players := status.Metadata.Players[:0]
+		for _, p := range status.Metadata.Players {
+			if p.ID != player.ID {
+				players = append(players, p)
+			}
+		}
+		status.Metadata.Players = players
+	})
+	if err != nil {
+		return statusFor(err), err
+	}
+	return http.StatusNoContent, nil
+}
+
+// setState replaces the metadata state of the instance
+func (s *Server) setState(ctx context.Context, id string, body json.RawMessage) (int, error) {
+	if !isObject(body) {
+		return http.StatusBadRequest, errors.New("state must be a JSON object")
+	}
+
+	_, err := s.updateStatus(ctx, id, func(status *instancev1.InstanceStatus) {
+		status.Metadata.State = body
+	})
+	if err != nil {
+		return statusFor(err), err
+	}
+	return http.StatusNoContent, nil
+}
+
+// updateStatus applies mutate to the status of the Instance with the given ID and writes it.
+// The Instance is read bypassing the cache, so retries on conflicts start from its latest version.
+func (s *Server) updateStatus(
+	ctx context.Context,
+	id string,
+	mutate func(status *instancev1.InstanceStatus),
+) (*instancev1.Instance, error) {
+	found, err := s.findInstance(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	key := client.ObjectKey{Namespace: found.Namespace, Name: found.Name}
+
+	instance := &instancev1.Instance{}
+	err = retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		instance = &instancev1.Instance{}
+		if err := s.reader().Get(ctx, key, instance); err != nil {
+			return err
+		}
+		if instance.Status.ID != id {
+			return errNotFound
+		}
+		mutate(&instance.Status)
+		return s.Client.Status().Update(ctx, instance)
+	})
+	return instance, err
+}
+
+func (s *Server) reader() client.Reader {
+	if s.APIReader != nil {
+		return s.APIReader
+	}
+	return s.Client
+}
+
+func (s *Server) findInstance(ctx context.Context, id string) (*instancev1.Instance, error) {
+	var list instancev1.InstanceList
+	if err := s.Client.List(ctx, &list, client.MatchingFields{instanceIDKey: id}); err != nil {
+		return nil, err
+	}
+	for i := range list.Items {
+		if list.Items[i].Status.ID == id {
+			return &list.Items[i], nil
+		}
+	}
+	return nil, errNotFound
+}
+
+func isObject(raw json.RawMessage) bool {
+	var object map[string]interface{}
+	return json.Unmarshal(raw, &object) == nil && object != nil
+}
+
+func statusFor(err error) int {
+	switch {
+	case err == errNotFound || apierrors.IsNotFound(err):
+		return http.StatusNotFound
+	case apierrors.IsInvalid(err) || apierrors.IsBadRequest(err):
+		return http.StatusBadRequest
+	case apierrors.IsConflict(err):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,119 @@
+package playerapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	instancev1 "github.com/cownetwork/instance-controller/api/v1"
+)
+
+const testID = "b3c6d2ca-1b1a-4a47-a3a4-52d7a0a5a8c3"
+
+var testSecret = []byte("secret")
+
+func newTestServer(t *testing.T) *Server {
+	scheme := runtime.NewScheme()
+	if err := instancev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	instance := &instancev1.Instance{}
+	instance.Name = "lobby"
+	instance.Namespace = "default"
+	instance.Status.ID = testID
+	instance.Status.Metadata.Players = []instancev1.InstancePlayer{{ID: "steve"}}
+
+	return &Server{
+		Client: fake.NewFakeClientWithScheme(scheme, instance),
+		Log:    zap.New(zap.UseDevMode(true)),
+		Secret: testSecret,
+	}
+}
+
+func do(s *Server, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("X-Instance-Id", testID)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func players(t *testing.T, s *Server) []instancev1.InstancePlayer {
+	var instance instancev1.Instance
+	if err := s.Client.Get(context.Background(), client.ObjectKey{Name: "lobby", Namespace: "default"}, &instance); err != nil {
+		t.Fatal(err)
+	}
+	return instance.Status.Metadata.Players
+}
+
+func TestUnauthorized(t *testing.T) {
+	s := newTestServer(t)
+	rec := do(s, http.MethodPost, "/v1/players/join", Token([]byte("other"), testID), `{"id":"alex"}`)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestPlayerJoinedAndLeft(t *testing.T) {
+	s := newTestServer(t)
+	token := Token(testSecret, testID)
+
+	rec := do(s, http.MethodPost, "/v1/players/join", token, `{"id":"alex","metadata":{"team":"red"}}`)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("join: got status %d: %s", rec.Code, rec.Body)
+	}
+	if got := players(t, s); len(got) != 2 || got[1].ID != "alex" {
+		t.Errorf("players after join = %v", got)
+	}
+
+	if rec := do(s, http.MethodPost, "/v1/players/join", token, `{"id":"sam","metadata":"red"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for metadata that is not an object, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// joining twice only updates the metadata
+	if rec := do(s, http.MethodPost, "/v1/players/join", token, `{"id":"alex","metadata":{"team":"blue"}}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("second join: got status %d: %s", rec.Code, rec.Body)
+	}
+	if got := players(t, s); len(got) != 2 || string(got[1].Metadata) != `{"team":"blue"}` {
+		t.Errorf("players after second join = %v", got)
+	}
+
+	if rec := do(s, http.MethodPost, "/v1/players/leave", token, `{"id":"steve"}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("leave: got status %d: %s", rec.Code, rec.Body)
+	}
+	if got := players(t, s); len(got) != 1 || got[0].ID != "alex" {
+		t.Errorf("players after leave = %v", got)
+	}
+}
+
+func TestSetState(t *testing.T) {
+	s := newTestServer(t)
+	token := Token(testSecret, testID)
+
+	if rec := do(s, http.MethodPut, "/v1/state", token, `["not","an","object"]`); rec.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for an array, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := do(s, http.MethodPost, "/v1/state", token, `{}`); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("got status %d for POST, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	if rec := do(s, http.MethodPut, "/v1/state", token, `{"map":"castle"}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+	}
+
+	var instance instancev1.Instance
+	if err := s.Client.Get(context.Background(), client.ObjectKey{Name: "lobby", Namespace: "default"}, &instance); err != nil {
+		t.Fatal(err)
+	}
+	if string(instance.Status.Metadata.State) != `{"map":"castle"}` {
+		t.Errorf("state = %s", instance.Status.Metadata.State)
+	}
+}